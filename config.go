@@ -0,0 +1,559 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the configuration file, an alternative to the environment
+// variables. Every setting has the environment variable it stands for next to
+// it, a variable that is set overrides the setting. Durations are in seconds.
+type Config struct {
+	Method                 string                `json:"method"`                 // METHOD
+	SleepTime              *float64              `json:"sleepTime"`              // SLEEP_TIME
+	Namespaces             []string              `json:"namespaces"`             // NAMESPACE
+	NamespaceSelector      string                `json:"namespaceSelector"`      // NAMESPACE_SELECTOR
+	Label                  string                `json:"label"`                  // LABEL
+	LabelValue             string                `json:"labelValue"`             // LABEL_VALUE
+	Resource               string                `json:"resource"`               // RESOURCE
+	ResourceNames          []string              `json:"resourceNames"`          // RESOURCE_NAME
+	Folder                 string                `json:"folder"`                 // FOLDER
+	FolderAnnotation       string                `json:"folderAnnotation"`       // FOLDER_ANNOTATION
+	UniqueFilenames        *bool                 `json:"uniqueFilenames"`        // UNIQUE_FILENAMES
+	IgnoreAlreadyProcessed *bool                 `json:"ignoreAlreadyProcessed"` // IGNORE_ALREADY_PROCESSED
+	StateFile              string                `json:"stateFile"`              // STATE_FILE
+	SecretMetadataOnly     *bool                 `json:"secretMetadataOnly"`     // SECRET_METADATA_ONLY
+	CustomResource         *CustomResourceConfig `json:"customResource"`
+	Notifier               *NotifierConfig       `json:"notifier"`
+	Kubernetes             *KubernetesConfig     `json:"kubernetes"`
+	Init                   *InitConfig           `json:"init"`
+	LeaderElection         *LeaderElectionConfig `json:"leaderElection"`
+	ResyncPeriod           *float64              `json:"resyncPeriod"`         // RESYNC_PERIOD
+	WatchServerTimeout     *float64              `json:"watchServerTimeout"`   // WATCH_SERVER_TIMEOUT
+	ClusterWideWatch       *bool                 `json:"clusterWideWatch"`     // CLUSTER_WIDE_WATCH
+	RepairLocalChanges     *bool                 `json:"repairLocalChanges"`   // REPAIR_LOCAL_CHANGES
+	CacheSyncTimeout       *float64              `json:"cacheSyncTimeout"`     // CACHE_SYNC_TIMEOUT
+	SkipRBACCheck          *bool                 `json:"skipRBACCheck"`        // SKIP_RBAC_CHECK
+	DisableEvents          *bool                 `json:"disableEvents"`        // DISABLE_EVENTS
+	SyncStatusAnnotation   *bool                 `json:"syncStatusAnnotation"` // SYNC_STATUS_ANNOTATION
+	MetricsPort            *int                  `json:"metricsPort"`          // METRICS_PORT
+	HealthPort             *int                  `json:"healthPort"`           // HEALTH_PORT
+	ReadyFile              string                `json:"readyFile"`            // READY_FILE
+	ShutdownGracePeriod    *float64              `json:"shutdownGracePeriod"`  // SHUTDOWN_GRACE_PERIOD
+	Profiles               []ProfileConfig       `json:"profiles"`
+
+	// overridden maps the settings overridden by environment variables to
+	// the variables
+	overridden map[string]string
+}
+
+// ProfileConfig is one of several sync profiles run by one sidecar, each with
+// its own selection, folder and notifier. Settings a profile leaves out are
+// taken from the top level.
+type ProfileConfig struct {
+	Name               string                `json:"name"`
+	Namespaces         []string              `json:"namespaces"`
+	NamespaceSelector  string                `json:"namespaceSelector"`
+	Label              string                `json:"label"`
+	LabelValue         string                `json:"labelValue"`
+	Resource           string                `json:"resource"`
+	ResourceNames      []string              `json:"resourceNames"`
+	Folder             string                `json:"folder"`
+	FolderAnnotation   string                `json:"folderAnnotation"`
+	SecretMetadataOnly *bool                 `json:"secretMetadataOnly"`
+	CustomResource     *CustomResourceConfig `json:"customResource"`
+	Notifier           *NotifierConfig       `json:"notifier"`
+}
+
+type CustomResourceConfig struct {
+	Resource string `json:"resource"` // CUSTOM_RESOURCE
+	FileName string `json:"fileName"` // CUSTOM_RESOURCE_FILENAME
+	Content  string `json:"content"`  // CUSTOM_RESOURCE_CONTENT
+}
+
+type NotifierConfig struct {
+	URL      string `json:"url"`      // REQ_URL
+	Method   string `json:"method"`   // REQ_METHOD
+	Payload  string `json:"payload"`  // REQ_PAYLOAD
+	Username string `json:"username"` // REQ_USERNAME
+	Password string `json:"password"` // REQ_PASSWORD
+	SkipInit *bool  `json:"skipInit"` // REQ_SKIP_INIT
+}
+
+type KubernetesConfig struct {
+	Kubeconfig                string   `json:"kubeconfig"`                // KUBECONFIG
+	Context                   string   `json:"context"`                   // KUBE_CONTEXT
+	QPS                       *float64 `json:"qps"`                       // KUBE_QPS
+	Burst                     *int     `json:"burst"`                     // KUBE_BURST
+	UserAgent                 string   `json:"userAgent"`                 // KUBE_USER_AGENT
+	ImpersonateUser           string   `json:"impersonateUser"`           // IMPERSONATE_USER
+	ImpersonateGroups         []string `json:"impersonateGroups"`         // IMPERSONATE_GROUPS
+	ImpersonateServiceAccount string   `json:"impersonateServiceAccount"` // IMPERSONATE_SERVICE_ACCOUNT
+}
+
+type InitConfig struct {
+	Strict        *bool    `json:"strict"`        // INIT_STRICT
+	MinFiles      *int     `json:"minFiles"`      // INIT_MIN_FILES
+	Resources     []string `json:"resources"`     // INIT_RESOURCES
+	Timeout       *float64 `json:"timeout"`       // INIT_TIMEOUT
+	RetryInterval *float64 `json:"retryInterval"` // INIT_RETRY_INTERVAL
+}
+
+type LeaderElectionConfig struct {
+	Enabled       *bool    `json:"enabled"`       // LEADER_ELECTION
+	Name          string   `json:"name"`          // LEADER_ELECTION_NAME
+	Namespace     string   `json:"namespace"`     // LEADER_ELECTION_NAMESPACE
+	Identity      string   `json:"identity"`      // LEADER_ELECTION_IDENTITY
+	LeaseDuration *float64 `json:"leaseDuration"` // LEADER_ELECTION_LEASE_DURATION
+}
+
+// LoadConfig reads and validates the YAML or JSON configuration file at path.
+// Unknown and misspelled settings are errors, so a typo does not silently
+// fall back to a default.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+func parseConfig(data []byte) (*Config, error) {
+	// duplicate keys are rejected as well
+	data, err := yaml.YAMLToJSONStrict(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return &Config{}, nil
+	}
+	if errs := unknownFields(fields, reflect.TypeFor[Config](), ""); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return nil, fmt.Errorf("%s: expected %s, got %s", typeErr.Field, typeName(typeErr.Type), typeErr.Value)
+		}
+		return nil, err
+	}
+
+	if errs := config.validate(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return config, nil
+}
+
+// unknownFields returns an error for every key of value that is not a field
+// of t, suggesting the field that was probably meant.
+func unknownFields(value any, t reflect.Type, path string) []error {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return unknownFields(value, t.Elem(), path)
+	case reflect.Slice:
+		items, _ := value.([]any)
+		var errs []error
+		for i, item := range items {
+			errs = append(errs, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	case reflect.Struct:
+	default:
+		return nil
+	}
+
+	object, ok := value.(map[string]any)
+	if !ok {
+		if value == nil {
+			return nil
+		}
+		return []error{fmt.Errorf("%s: expected an object", strings.TrimPrefix(path, "."))}
+	}
+
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = t.Field(i).Type
+	}
+
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(object)) {
+		fieldType, ok := fields[key]
+		if !ok {
+			err := fmt.Sprintf("%s: unknown setting", strings.TrimPrefix(path+"."+key, "."))
+			if suggestion := closest(key, fields); suggestion != "" {
+				err += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			errs = append(errs, errors.New(err))
+			continue
+		}
+		errs = append(errs, unknownFields(object[key], fieldType, path+"."+key)...)
+	}
+
+	return errs
+}
+
+// closest returns the field name closest to the misspelled name, or nothing
+// when none is close enough to be a typo.
+func closest(name string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+	for _, candidate := range slices.Sorted(maps.Keys(fields)) {
+		if strings.EqualFold(candidate, name) {
+			return candidate
+		}
+		if distance := editDistance(strings.ToLower(name), strings.ToLower(candidate)); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+
+	return best
+}
+
+// editDistance is the Levenshtein distance of a and b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int:
+		return "an integer"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Struct:
+		return "an object"
+	}
+
+	return "a " + t.Kind().String()
+}
+
+// validate checks the values of the settings.
+func (c *Config) validate() []error {
+	var errs []error
+	oneOf := func(setting string, value string, values ...string) {
+		if value != "" && !slices.Contains(values, value) {
+			errs = append(errs, fmt.Errorf("%s: must be one of %s, got %q", c.name(setting), strings.Join(values, ", "), value))
+		}
+	}
+	notNegative := func(setting string, value *float64) {
+		if value != nil && *value < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative, got %v", c.name(setting), *value))
+		}
+	}
+	port := func(setting string, value *int) {
+		if value != nil && (*value < 1 || *value > 65535) {
+			errs = append(errs, fmt.Errorf("%s: must be a port between 1 and 65535, got %d", c.name(setting), *value))
+		}
+	}
+
+	selection := func(prefix string, resource string, namespaceSelector string, custom *CustomResourceConfig, notifier *NotifierConfig) {
+		oneOf(prefix+"resource", resource, RESOURCE_CONFIGMAP, RESOURCE_SECRET, RESOURCE_ALL, RESOURCE_CUSTOM)
+		if namespaceSelector != "" {
+			if _, err := labels.Parse(namespaceSelector); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", c.name(prefix+"namespaceSelector"), err))
+			}
+		}
+		if resource == RESOURCE_CUSTOM && (custom == nil || custom.Resource == "") &&
+			(c.CustomResource == nil || c.CustomResource.Resource == "") {
+			errs = append(errs, fmt.Errorf("%s: required with resource custom", c.name(prefix+"customResource.resource")))
+		}
+		if notifier != nil {
+			oneOf(prefix+"notifier.method", strings.ToUpper(notifier.Method), "GET", "POST")
+		}
+	}
+
+	oneOf("method", strings.ToLower(c.Method), METHOD_WATCH, METHOD_LIST, METHOD_SLEEP)
+	selection("", c.Resource, c.NamespaceSelector, c.CustomResource, c.Notifier)
+
+	names := map[string]struct{}{}
+	for i, profile := range c.Profiles {
+		prefix := fmt.Sprintf("profiles[%d].", i)
+		switch _, duplicate := names[profile.Name]; {
+		case profile.Name == "":
+			errs = append(errs, fmt.Errorf("%sname: required", prefix))
+		case duplicate:
+			errs = append(errs, fmt.Errorf("%sname: %q is used by another profile", prefix, profile.Name))
+		default:
+			for _, msg := range validation.IsDNS1123Label(profile.Name) {
+				errs = append(errs, fmt.Errorf("%sname: %s", prefix, msg))
+			}
+		}
+		names[profile.Name] = struct{}{}
+
+		selection(prefix, profile.Resource, profile.NamespaceSelector, profile.CustomResource, profile.Notifier)
+	}
+	if c.Kubernetes != nil {
+		notNegative("kubernetes.qps", c.Kubernetes.QPS)
+		if c.Kubernetes.Burst != nil && *c.Kubernetes.Burst < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative, got %d", c.name("kubernetes.burst"), *c.Kubernetes.Burst))
+		}
+	}
+	if c.Init != nil {
+		if c.Init.MinFiles != nil && *c.Init.MinFiles < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative, got %d", c.name("init.minFiles"), *c.Init.MinFiles))
+		}
+		notNegative("init.timeout", c.Init.Timeout)
+		notNegative("init.retryInterval", c.Init.RetryInterval)
+	}
+	if c.LeaderElection != nil {
+		notNegative("leaderElection.leaseDuration", c.LeaderElection.LeaseDuration)
+	}
+	notNegative("sleepTime", c.SleepTime)
+	notNegative("resyncPeriod", c.ResyncPeriod)
+	notNegative("watchServerTimeout", c.WatchServerTimeout)
+	notNegative("cacheSyncTimeout", c.CacheSyncTimeout)
+	notNegative("shutdownGracePeriod", c.ShutdownGracePeriod)
+	port("metricsPort", c.MetricsPort)
+	port("healthPort", c.HealthPort)
+
+	return errs
+}
+
+// setting ties a setting of the file to the environment variable standing
+// for it, value points to the field holding it.
+type setting struct {
+	name  string
+	key   string
+	value any
+}
+
+// settings returns the settings environment variables stand for. The nested
+// settings are allocated, so every field can be set.
+func (c *Config) settings() []setting {
+	if c.CustomResource == nil {
+		c.CustomResource = &CustomResourceConfig{}
+	}
+	if c.Notifier == nil {
+		c.Notifier = &NotifierConfig{}
+	}
+	if c.Kubernetes == nil {
+		c.Kubernetes = &KubernetesConfig{}
+	}
+	if c.Init == nil {
+		c.Init = &InitConfig{}
+	}
+	if c.LeaderElection == nil {
+		c.LeaderElection = &LeaderElectionConfig{}
+	}
+
+	return []setting{
+		{"method", METHOD, &c.Method},
+		{"sleepTime", SLEEP_TIME, &c.SleepTime},
+		{"namespaces", NAMESPACE, &c.Namespaces},
+		{"namespaceSelector", NAMESPACE_SELECTOR, &c.NamespaceSelector},
+		{"label", LABEL, &c.Label},
+		{"labelValue", LABEL_VALUE, &c.LabelValue},
+		{"resource", RESOURCE, &c.Resource},
+		{"resourceNames", RESOURCE_NAME, &c.ResourceNames},
+		{"folder", FOLDER, &c.Folder},
+		{"folderAnnotation", FOLDER_ANNOTATION, &c.FolderAnnotation},
+		{"uniqueFilenames", UNIQUE_FILENAMES, &c.UniqueFilenames},
+		{"ignoreAlreadyProcessed", IGNORE_ALREADY_PROCESSED, &c.IgnoreAlreadyProcessed},
+		{"stateFile", STATE_FILE, &c.StateFile},
+		{"secretMetadataOnly", SECRET_METADATA_ONLY, &c.SecretMetadataOnly},
+		{"customResource.resource", CUSTOM_RESOURCE, &c.CustomResource.Resource},
+		{"customResource.fileName", CUSTOM_RESOURCE_FILENAME, &c.CustomResource.FileName},
+		{"customResource.content", CUSTOM_RESOURCE_CONTENT, &c.CustomResource.Content},
+		{"notifier.url", REQ_URL, &c.Notifier.URL},
+		{"notifier.method", REQ_METHOD, &c.Notifier.Method},
+		{"notifier.payload", REQ_PAYLOAD, &c.Notifier.Payload},
+		{"notifier.username", REQ_USERNAME, &c.Notifier.Username},
+		{"notifier.password", REQ_PASSWORD, &c.Notifier.Password},
+		{"notifier.skipInit", REQ_SKIP_INIT, &c.Notifier.SkipInit},
+		{"kubernetes.kubeconfig", KUBECONFIG, &c.Kubernetes.Kubeconfig},
+		{"kubernetes.context", KUBE_CONTEXT, &c.Kubernetes.Context},
+		{"kubernetes.qps", KUBE_QPS, &c.Kubernetes.QPS},
+		{"kubernetes.burst", KUBE_BURST, &c.Kubernetes.Burst},
+		{"kubernetes.userAgent", KUBE_USER_AGENT, &c.Kubernetes.UserAgent},
+		{"kubernetes.impersonateUser", IMPERSONATE_USER, &c.Kubernetes.ImpersonateUser},
+		{"kubernetes.impersonateGroups", IMPERSONATE_GROUPS, &c.Kubernetes.ImpersonateGroups},
+		{"kubernetes.impersonateServiceAccount", IMPERSONATE_SERVICE_ACCOUNT, &c.Kubernetes.ImpersonateServiceAccount},
+		{"init.strict", INIT_STRICT, &c.Init.Strict},
+		{"init.minFiles", INIT_MIN_FILES, &c.Init.MinFiles},
+		{"init.resources", INIT_RESOURCES, &c.Init.Resources},
+		{"init.timeout", INIT_TIMEOUT, &c.Init.Timeout},
+		{"init.retryInterval", INIT_RETRY_INTERVAL, &c.Init.RetryInterval},
+		{"leaderElection.enabled", LEADER_ELECTION, &c.LeaderElection.Enabled},
+		{"leaderElection.name", LEADER_ELECTION_NAME, &c.LeaderElection.Name},
+		{"leaderElection.namespace", LEADER_ELECTION_NAMESPACE, &c.LeaderElection.Namespace},
+		{"leaderElection.identity", LEADER_ELECTION_IDENTITY, &c.LeaderElection.Identity},
+		{"leaderElection.leaseDuration", LEADER_ELECTION_LEASE_DURATION, &c.LeaderElection.LeaseDuration},
+		{"resyncPeriod", RESYNC_PERIOD, &c.ResyncPeriod},
+		{"watchServerTimeout", WATCH_SERVER_TIMEOUT, &c.WatchServerTimeout},
+		{"clusterWideWatch", CLUSTER_WIDE_WATCH, &c.ClusterWideWatch},
+		{"repairLocalChanges", REPAIR_LOCAL_CHANGES, &c.RepairLocalChanges},
+		{"cacheSyncTimeout", CACHE_SYNC_TIMEOUT, &c.CacheSyncTimeout},
+		{"skipRBACCheck", SKIP_RBAC_CHECK, &c.SkipRBACCheck},
+		{"disableEvents", DISABLE_EVENTS, &c.DisableEvents},
+		{"syncStatusAnnotation", SYNC_STATUS_ANNOTATION, &c.SyncStatusAnnotation},
+		{"metricsPort", METRICS_PORT, &c.MetricsPort},
+		{"healthPort", HEALTH_PORT, &c.HealthPort},
+		{"readyFile", READY_FILE, &c.ReadyFile},
+		{"shutdownGracePeriod", SHUTDOWN_GRACE_PERIOD, &c.ShutdownGracePeriod},
+	}
+}
+
+// format returns the setting as the value of its environment variable, empty
+// when it is not set.
+func (s setting) format() string {
+	switch value := s.value.(type) {
+	case *string:
+		return *value
+	case *[]string:
+		return strings.Join(*value, ",")
+	case **bool:
+		if *value != nil {
+			return strconv.FormatBool(**value)
+		}
+	case **int:
+		if *value != nil {
+			return strconv.Itoa(**value)
+		}
+	case **float64:
+		if *value != nil {
+			return strconv.FormatFloat(**value, 'f', -1, 64)
+		}
+	}
+
+	return ""
+}
+
+// parse sets the setting from the value of its environment variable, an
+// empty value unsets it.
+func (s setting) parse(env string) error {
+	switch value := s.value.(type) {
+	case *string:
+		*value = env
+	case *[]string:
+		*value = nil
+		if env != "" {
+			*value = strings.Split(env, ",")
+		}
+	case **bool:
+		*value = nil
+		if env != "" {
+			parsed, err := strconv.ParseBool(env)
+			if err != nil {
+				return err
+			}
+			*value = &parsed
+		}
+	case **int:
+		*value = nil
+		if env != "" {
+			parsed, err := strconv.Atoi(env)
+			if err != nil {
+				return err
+			}
+			*value = &parsed
+		}
+	case **float64:
+		*value = nil
+		if env != "" {
+			parsed, err := strconv.ParseFloat(env, 64)
+			if err != nil {
+				return err
+			}
+			*value = &parsed
+		}
+	}
+
+	return nil
+}
+
+// applyEnv overrides the settings with the environment variables that are
+// set. A variable set to an empty value unsets the setting of the file.
+func (c *Config) applyEnv() []error {
+	var errs []error
+	for _, s := range c.settings() {
+		value, ok := os.LookupEnv(s.key)
+		if !ok {
+			continue
+		}
+		if err := s.parse(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: expected %s, got %q", s.key, typeName(reflect.TypeOf(s.value).Elem()), value))
+			continue
+		}
+
+		if c.overridden == nil {
+			c.overridden = map[string]string{}
+		}
+		c.overridden[s.name] = s.key
+	}
+
+	return errs
+}
+
+// name returns how errors refer to the setting, the environment variable
+// when it overrides the file.
+func (c *Config) name(setting string) string {
+	if key, ok := c.overridden[setting]; ok {
+		return key
+	}
+
+	return setting
+}
+
+// env returns the settings as the values of the environment variables they
+// stand for, settings that are not set are left out.
+func (c *Config) env() env {
+	e := env{}
+	for _, s := range c.settings() {
+		if value := s.format(); value != "" {
+			e[s.key] = value
+		}
+	}
+
+	return e
+}
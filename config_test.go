@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := parseConfig([]byte(`
+method: watch
+namespaces: [monitoring, grafana]
+label: grafana_dashboard
+labelValue: "1"
+resource: both
+resourceNames: [team-*]
+folder: /tmp/dashboards
+notifier:
+  url: http://localhost:3000/api/admin/provisioning/dashboards/reload
+  method: POST
+  skipInit: true
+kubernetes:
+  qps: 20
+  impersonateGroups: [system:authenticated, viewers]
+init:
+  minFiles: 3
+  timeout: 120
+leaderElection:
+  enabled: true
+resyncPeriod: 0
+cacheSyncTimeout: 1.5
+metricsPort: 8080
+`))
+	if err != nil {
+		t.Fatalf("Expected the config to parse, got %v", err)
+	}
+
+	expected := env{
+		METHOD:             "watch",
+		NAMESPACE:          "monitoring,grafana",
+		LABEL:              "grafana_dashboard",
+		LABEL_VALUE:        "1",
+		RESOURCE:           "both",
+		RESOURCE_NAME:      "team-*",
+		FOLDER:             "/tmp/dashboards",
+		REQ_URL:            "http://localhost:3000/api/admin/provisioning/dashboards/reload",
+		REQ_METHOD:         "POST",
+		REQ_SKIP_INIT:      "true",
+		KUBE_QPS:           "20",
+		IMPERSONATE_GROUPS: "system:authenticated,viewers",
+		INIT_MIN_FILES:     "3",
+		INIT_TIMEOUT:       "120",
+		LEADER_ELECTION:    "true",
+		RESYNC_PERIOD:      "0",
+		CACHE_SYNC_TIMEOUT: "1.5",
+		METRICS_PORT:       "8080",
+	}
+	if actual := config.env(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected settings %v, got %v", expected, actual)
+	}
+}
+
+func TestParseConfig_JSON(t *testing.T) {
+	config, err := parseConfig([]byte(`{"method": "list", "label": "app", "init": {"strict": false}}`))
+	if err != nil {
+		t.Fatalf("Expected the config to parse, got %v", err)
+	}
+
+	expected := env{METHOD: "list", LABEL: "app", INIT_STRICT: "false"}
+	if actual := config.env(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected settings %v, got %v", expected, actual)
+	}
+}
+
+func TestParseConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errors []string
+	}{
+		{
+			name:   "typo",
+			config: "lable: app\n",
+			errors: []string{`lable: unknown setting, did you mean "label"?`},
+		},
+		{
+			name:   "wrong case",
+			config: "labelvalue: \"1\"\n",
+			errors: []string{`labelvalue: unknown setting, did you mean "labelValue"?`},
+		},
+		{
+			name:   "nested typo",
+			config: "notifier:\n  ulr: http://localhost\n",
+			errors: []string{`notifier.ulr: unknown setting, did you mean "url"?`},
+		},
+		{
+			name:   "unknown",
+			config: "dashboards: true\n",
+			errors: []string{"dashboards: unknown setting"},
+		},
+		{
+			name:   "all unknown settings",
+			config: "lable: app\nfodler: /tmp\n",
+			errors: []string{`fodler: unknown setting, did you mean "folder"?`, `lable: unknown setting, did you mean "label"?`},
+		},
+		{
+			name:   "not an object",
+			config: "notifier: http://localhost\n",
+			errors: []string{"notifier: expected an object"},
+		},
+		{
+			name:   "wrong type",
+			config: "sleepTime: 1m\n",
+			errors: []string{"sleepTime: expected a number, got string"},
+		},
+		{
+			name:   "duplicate",
+			config: "label: app\nlabel: other\n",
+			errors: []string{`"label" already set in map`},
+		},
+		{
+			name:   "method",
+			config: "method: wach\n",
+			errors: []string{`method: must be one of watch, list, sleep, got "wach"`},
+		},
+		{
+			name:   "resource",
+			config: "resource: configmaps\n",
+			errors: []string{`resource: must be one of configmap, secret, both, custom, got "configmaps"`},
+		},
+		{
+			name:   "custom resource",
+			config: "resource: custom\n",
+			errors: []string{"customResource.resource: required with resource custom"},
+		},
+		{
+			name:   "namespace selector",
+			config: "namespaceSelector: a=b=c\n",
+			errors: []string{"namespaceSelector: "},
+		},
+		{
+			name:   "profile typo",
+			config: "profiles:\n  - name: dashboards\n    fodler: /tmp\n",
+			errors: []string{`profiles[0].fodler: unknown setting, did you mean "folder"?`},
+		},
+		{
+			name:   "profile name",
+			config: "profiles:\n  - label: app\n  - name: Dashboards\n",
+			errors: []string{"profiles[0].name: required", "profiles[1].name: a lowercase RFC 1123 label"},
+		},
+		{
+			name:   "duplicate profile",
+			config: "profiles:\n  - name: dashboards\n  - name: dashboards\n",
+			errors: []string{`profiles[1].name: "dashboards" is used by another profile`},
+		},
+		{
+			name:   "profile resource",
+			config: "profiles:\n  - name: dashboards\n    resource: custom\n",
+			errors: []string{"profiles[0].customResource.resource: required with resource custom"},
+		},
+		{
+			name:   "negative",
+			config: "init:\n  timeout: -1\n",
+			errors: []string{"init.timeout: must not be negative, got -1"},
+		},
+		{
+			name:   "port",
+			config: "healthPort: 80800\n",
+			errors: []string{"healthPort: must be a port between 1 and 65535, got 80800"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig([]byte(tt.config))
+			if err == nil {
+				t.Fatal("Expected the config to be rejected")
+			}
+			for _, expected := range tt.errors {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected error %q, got %q", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConfig_Profiles(t *testing.T) {
+	config, err := parseConfig([]byte(`
+label: grafana_dashboard
+resource: custom
+customResource:
+  resource: grafanadashboards.v1.grafana.integreatly.org
+profiles:
+  - name: dashboards
+    folder: /tmp/dashboards
+  - name: datasources
+    label: grafana_datasource
+    resource: configmap
+    notifier:
+      url: http://localhost:3000/api/admin/provisioning/datasources/reload
+`))
+	if err != nil {
+		t.Fatalf("Expected the config to parse, got %v", err)
+	}
+
+	if len(config.Profiles) != 2 {
+		t.Fatalf("Expected 2 profiles, got %d", len(config.Profiles))
+	}
+	if config.Profiles[1].Notifier == nil || config.Profiles[1].Notifier.URL == "" {
+		t.Errorf("Expected the notifier of the datasources profile, got %v", config.Profiles[1].Notifier)
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	unsetenv(t, METHOD, NAMESPACE, LABEL, LABEL_VALUE, RESOURCE, FOLDER, SLEEP_TIME, REQ_URL)
+	setKubeconfig(t)
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte(`
+method: sleep
+sleepTime: 30
+namespaces: [monitoring]
+label: grafana_dashboard
+resource: configmap
+folder: /tmp/dashboards
+`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	// environment variables override the config file
+	t.Setenv(FOLDER, "/tmp/override")
+
+	sideCar, err := NewFromConfig(context.Background(), configFile)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+
+	if sideCar.Method != METHOD_SLEEP {
+		t.Errorf("Expected method %s, got %s", METHOD_SLEEP, sideCar.Method)
+	}
+	if sideCar.SleepTime != 30*time.Second {
+		t.Errorf("Expected sleep time 30s, got %s", sideCar.SleepTime)
+	}
+	if !reflect.DeepEqual(sideCar.Namespaces, []string{"monitoring"}) {
+		t.Errorf("Expected namespaces [monitoring], got %v", sideCar.Namespaces)
+	}
+	if sideCar.Label != "grafana_dashboard" {
+		t.Errorf("Expected label grafana_dashboard, got %s", sideCar.Label)
+	}
+	if !reflect.DeepEqual(sideCar.Resource, []string{RESOURCE_CONFIGMAP}) {
+		t.Errorf("Expected resources [configmap], got %v", sideCar.Resource)
+	}
+	if sideCar.Folder != "/tmp/override" {
+		t.Errorf("Expected the folder of the environment variable, got %s", sideCar.Folder)
+	}
+	if sideCar.notifier != nil {
+		t.Errorf("Expected no notifier without REQ_URL, got %v", sideCar.notifier)
+	}
+}
+
+// unsetenv unsets the environment variables during the test, a variable set
+// to an empty value overrides the config file.
+func unsetenv(t *testing.T, keys ...string) {
+	t.Helper()
+
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func TestNewFromConfig_EnvValidated(t *testing.T) {
+	unsetenv(t, METHOD, NAMESPACE, LABEL, RESOURCE, FOLDER, INIT_STRICT, METRICS_PORT)
+	setKubeconfig(t)
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte("resource: configmap\nfolder: /tmp/dashboards\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv(RESOURCE, "configmaps")
+	t.Setenv(INIT_STRICT, "yes")
+	t.Setenv(METRICS_PORT, "80800")
+
+	_, err := NewFromConfig(context.Background(), configFile)
+	for _, expected := range []string{
+		`RESOURCE: must be one of configmap, secret, both, custom, got "configmaps"`,
+		`INIT_STRICT: expected true or false, got "yes"`,
+		"METRICS_PORT: must be a port between 1 and 65535, got 80800",
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+
+	// without a config file the environment variables are validated too
+	_, err = New(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "RESOURCE: must be one of") {
+		t.Errorf("Expected the environment variables to be rejected, got %v", err)
+	}
+}
+
+func TestNewFromConfig_EnvClears(t *testing.T) {
+	unsetenv(t, METHOD, NAMESPACE, LABEL, RESOURCE, FOLDER_ANNOTATION)
+	setKubeconfig(t)
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte("namespaces: [monitoring]\nlabel: grafana_dashboard\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	// an empty variable counts as set and clears the setting of the file
+	t.Setenv(NAMESPACE, "")
+
+	sideCar, err := NewFromConfig(context.Background(), configFile)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+	if len(sideCar.Namespaces) != 0 {
+		t.Errorf("Expected all namespaces, got %v", sideCar.Namespaces)
+	}
+	if sideCar.Label != "grafana_dashboard" {
+		t.Errorf("Expected label grafana_dashboard, got %s", sideCar.Label)
+	}
+}
+
+func TestNewFromConfig_Invalid(t *testing.T) {
+	setKubeconfig(t)
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte("lable: app\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	_, err := NewFromConfig(context.Background(), configFile)
+	if err == nil || !strings.Contains(err.Error(), configFile) || !strings.Contains(err.Error(), `did you mean "label"?`) {
+		t.Errorf("Expected the config file to be rejected, got %v", err)
+	}
+
+	_, err = NewFromConfig(context.Background(), filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "failed to read config file") {
+		t.Errorf("Expected the missing config file to fail, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+)
+
+// readyCheckInterval is how often the handlers are checked while the sidecar
+// waits for them to write the files.
+const readyCheckInterval = time.Second
+
+// healthz reports the sidecar as alive unless one of the informer workers
+// failed.
+func (s *SideCar) healthz(w http.ResponseWriter, r *http.Request) {
+	if err := s.client.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports the sidecar as ready once the initial sync wrote all files
+// and while no informer failed. A replica waiting for the Lease is ready as
+// well, the leader syncs for it.
+func (s *SideCar) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.client.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	s.startupz(w, r)
+}
+
+// startupz reports whether the initial sync is done. Unlike readyz it never
+// fails again afterwards, as a startup probe of a native sidecar it holds
+// back the app containers until their files are in place.
+func (s *SideCar) startupz(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case s.ready.Load():
+		fmt.Fprintln(w, "ok")
+	case s.LeaderElection && s.Method != METHOD_LIST && !s.leading.Load():
+		fmt.Fprintln(w, "standby")
+	default:
+		http.Error(w, "initial sync not done", http.StatusServiceUnavailable)
+	}
+}
+
+// markReady reports the sidecar as ready and writes the readiness marker
+// file.
+func (s *SideCar) markReady() {
+	s.readyOnce.Do(func() {
+		l.Info("Initial sync done, ready")
+		s.ready.Store(true)
+
+		if s.ReadyFile == "" {
+			return
+		}
+		if err := os.WriteFile(s.ReadyFile, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+			l.Error("Failed to write readiness file:", "path", s.ReadyFile, "error", err)
+		}
+	})
+}
+
+// removeReadyFile removes the readiness marker file of a previous run.
+func (s *SideCar) removeReadyFile() {
+	if s.ReadyFile == "" {
+		return
+	}
+
+	if err := os.Remove(s.ReadyFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		l.Error("Failed to remove readiness file:", "path", s.ReadyFile, "error", err)
+	}
+}
+
+// readyOnceSynced marks the sidecar as ready once all informer caches synced
+// and the files of every resource in them were written.
+func (s *SideCar) readyOnceSynced() {
+	ticker := time.NewTicker(readyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if s.client.FilesSynced() {
+				s.markReady()
+				return
+			}
+		}
+	}
+}
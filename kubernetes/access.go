@@ -0,0 +1,121 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Permission is an API request the sidecar has to be allowed to make. An
+// empty namespace means all namespaces.
+type Permission struct {
+	Group     string
+	Resource  string
+	Verb      string
+	Namespace string
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource = p.Resource + "." + p.Group
+	}
+
+	namespace := p.Namespace
+	if namespace == metav1.NamespaceAll {
+		namespace = "all namespaces"
+	}
+
+	return fmt.Sprintf("%s %s in %s", p.Verb, resource, namespace)
+}
+
+// MissingPermissions asks the API server with SelfSubjectAccessReviews which
+// of the permissions the service account lacks.
+func (c *Client) MissingPermissions(permissions []Permission) ([]Permission, error) {
+	var missing []Permission
+
+	for _, permission := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: permission.Namespace,
+					Verb:      permission.Verb,
+					Group:     permission.Group,
+					Resource:  permission.Resource,
+				},
+			},
+		}
+
+		result, err := c.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(c.Ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review access to %s: %w", permission, err)
+		}
+
+		if !result.Status.Allowed {
+			l.Debug("Permission denied:", "permission", permission.String(), "reason", result.Status.Reason)
+			missing = append(missing, permission)
+		}
+	}
+
+	return missing, nil
+}
+
+// SuggestedRole renders a ClusterRole for permissions in all namespaces and
+// a Role for every single namespace that grant the permissions.
+func SuggestedRole(name string, permissions []Permission) string {
+	// namespace -> group -> resource -> verbs
+	rules := map[string]map[string]map[string]map[string]struct{}{}
+	for _, p := range permissions {
+		if rules[p.Namespace] == nil {
+			rules[p.Namespace] = map[string]map[string]map[string]struct{}{}
+		}
+		if rules[p.Namespace][p.Group] == nil {
+			rules[p.Namespace][p.Group] = map[string]map[string]struct{}{}
+		}
+		if rules[p.Namespace][p.Group][p.Resource] == nil {
+			rules[p.Namespace][p.Group][p.Resource] = map[string]struct{}{}
+		}
+		rules[p.Namespace][p.Group][p.Resource][p.Verb] = struct{}{}
+	}
+
+	var b strings.Builder
+	for _, namespace := range sortedKeys(rules) {
+		if b.Len() > 0 {
+			b.WriteString("---\n")
+		}
+
+		b.WriteString("apiVersion: rbac.authorization.k8s.io/v1\n")
+		if namespace == metav1.NamespaceAll {
+			b.WriteString("kind: ClusterRole\n")
+			fmt.Fprintf(&b, "metadata:\n  name: %s\n", name)
+		} else {
+			b.WriteString("kind: Role\n")
+			fmt.Fprintf(&b, "metadata:\n  name: %s\n  namespace: %s\n", name, namespace)
+		}
+
+		b.WriteString("rules:\n")
+		for _, group := range sortedKeys(rules[namespace]) {
+			for _, resource := range sortedKeys(rules[namespace][group]) {
+				verbs := sortedKeys(rules[namespace][group][resource])
+				fmt.Fprintf(&b, "- apiGroups: [%q]\n", group)
+				fmt.Fprintf(&b, "  resources: [%q]\n", resource)
+				fmt.Fprintf(&b, "  verbs: [\"%s\"]\n", strings.Join(verbs, `", "`))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,55 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// allowReviews answers SelfSubjectAccessReviews with allow.
+func allowReviews(clientset *fake.Clientset, allow func(attributes *authorizationv1.ResourceAttributes) bool) {
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+		review.Status.Allowed = allow(review.Spec.ResourceAttributes)
+		return true, review, nil
+	})
+}
+
+func TestMissingPermissions(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset()
+	allowReviews(fakeClientset, func(attributes *authorizationv1.ResourceAttributes) bool {
+		return attributes.Resource == "configmaps"
+	})
+
+	c := &Client{Ctx: context.Background(), Client: fakeClientset}
+
+	missing, err := c.MissingPermissions([]Permission{
+		{Resource: "configmaps", Verb: "list", Namespace: "monitoring"},
+		{Resource: "secrets", Verb: "watch", Namespace: "monitoring"},
+		{Group: "grafana.integreatly.org", Resource: "grafanadashboards", Verb: "list"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to check permissions: %v", err)
+	}
+
+	if len(missing) != 2 || missing[0].Resource != "secrets" || missing[1].Resource != "grafanadashboards" {
+		t.Fatalf("Expected secrets and grafanadashboards to be missing, got %v", missing)
+	}
+
+	role := SuggestedRole("k8s-gsidecar", missing)
+	for _, expected := range []string{
+		"kind: ClusterRole",
+		`- apiGroups: ["grafana.integreatly.org"]`,
+		"kind: Role\nmetadata:\n  name: k8s-gsidecar\n  namespace: monitoring",
+		`  resources: ["secrets"]` + "\n" + `  verbs: ["watch"]`,
+	} {
+		if !strings.Contains(role, expected) {
+			t.Errorf("Expected suggested role to contain %q, got:\n%s", expected, role)
+		}
+	}
+}
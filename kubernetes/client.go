@@ -2,89 +2,300 @@ package kubernetes
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"k8s-gsidecar/logger"
-	"k8s-gsidecar/notifier"
-	"k8s-gsidecar/writer"
+	"k8s-gsidecar/state"
 	"log/slog"
-	"os"
-	"path"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 var l *slog.Logger = logger.GetLogger()
 
 type Client struct {
-	Ctx    context.Context
-	Client kubernetes.Interface
-	Wg     *sync.WaitGroup
+	Ctx      context.Context
+	Client   kubernetes.Interface
+	Metadata metadata.Interface
+	Dynamic  dynamic.Interface
+	Wg       *sync.WaitGroup
+
+	// State, when set, is shared by all workers to skip resources that were
+	// already processed with the same resourceVersion or content, unless
+	// their Target has a store of its own.
+	State *state.Store
+	// ClusterWide watches several namespaces with one cluster wide informer
+	// per kind instead of one informer per namespace, it needs a ClusterRole.
+	ClusterWide bool
+	// ResyncPeriod is how often the informers replay their cache to repair
+	// files changed or deleted locally, 0 disables resyncs.
+	ResyncPeriod time.Duration
+	// RepairLocalChanges watches the folders files are written to and
+	// writes files changed or deleted locally again from the informer cache.
+	RepairLocalChanges bool
+	// CacheSyncTimeout bounds how long a worker waits for its informer cache
+	// to sync before it fails, 0 waits until the context is done.
+	CacheSyncTimeout time.Duration
+	// WatchTimeout asks the API server to close watches after this long, the
+	// informers then reconnect. 0 uses the client-go default.
+	WatchTimeout time.Duration
+	// Events, when set, records Kubernetes Events on the synced resources.
+	Events record.EventRecorder
+	// SyncStatusIdentity, when set, reports the sync status of every
+	// resource in the StatusAnnotation of this identity.
+	SyncStatusIdentity string
+
+	mu                sync.Mutex
+	factories         map[factoryKey]informers.SharedInformerFactory
+	metadataFactories map[factoryKey]metadatainformer.SharedInformerFactory
+	dynamicFactories  map[factoryKey]dynamicinformer.DynamicSharedInformerFactory
+	errs              chan error
+	failed            error
+	// syncing and synced count the informers waiting for their cache to
+	// sync and those whose cache synced
+	syncing int
+	synced  int
+	// handlers counts the goroutines writing files outside of the informers
+	handlers sync.WaitGroup
+	// registered holds the handlers of the workers
+	registered []*handler
 }
 
-func NewClient(ctx context.Context) (*Client, error) {
-	var client kubernetes.Interface
-	if cfg, err := rest.InClusterConfig(); err == nil {
-		client, err = kubernetes.NewForConfig(cfg)
+// Errors returns a channel receiving the first error a worker failed with.
+func (c *Client) Errors() <-chan error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		if err != nil {
-			l.Error("Failed to create Kubernetes client", "error", err)
+	if c.errs == nil {
+		c.errs = make(chan error, 1)
+	}
+
+	return c.errs
+}
+
+// Err returns the first error a worker failed with.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.failed
+}
+
+// CachesSynced reports whether informers were started and all of their caches
+// synced.
+func (c *Client) CachesSynced() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.synced > 0 && c.syncing == 0
+}
+
+// FilesSynced reports whether all informer caches synced and the handlers
+// wrote the files of every resource in them without a failure.
+func (c *Client) FilesSynced() bool {
+	if !c.CachesSynced() {
+		return false
+	}
+
+	c.mu.Lock()
+	handlers := slices.Clone(c.registered)
+	c.mu.Unlock()
+
+	for _, h := range handlers {
+		if !h.synced() {
+			return false
 		}
+	}
+
+	return true
+}
+
+// fail reports a worker error, only the first one is kept. Errors caused by
+// shutting down are dropped.
+func (c *Client) fail(err error) {
+	if c.Ctx.Err() != nil {
+		return
+	}
+
+	l.Error("Worker failed:", "error", err)
+
+	c.mu.Lock()
+	if c.failed == nil {
+		c.failed = err
+	}
+	c.mu.Unlock()
 
-		return &Client{
-			Ctx:    ctx,
-			Client: client,
-		}, nil
+	c.Errors()
+	select {
+	case c.errs <- err:
+	default:
 	}
+}
+
+// Options configure how NewClient connects to the API server.
+type Options struct {
+	// Kubeconfig is the kubeconfig file used outside of a cluster. When it
+	// and Context are empty the in-cluster configuration is preferred and
+	// ~/.kube/config is the fallback.
+	Kubeconfig string
+	// Context selects a kubeconfig context instead of the current one.
+	Context string
+	// QPS and Burst limit the requests to the API server, 0 keeps the
+	// client-go defaults.
+	QPS   float32
+	Burst int
+	// UserAgent replaces the client-go default user agent.
+	UserAgent string
+	// ImpersonateUser and ImpersonateGroups make every request on behalf of
+	// another user, e.g. to check a least privilege role while debugging.
+	ImpersonateUser   string
+	ImpersonateGroups []string
+	// ImpersonateServiceAccount impersonates the service account given as
+	// namespace:name, it cannot be combined with ImpersonateUser.
+	ImpersonateServiceAccount string
+}
+
+// restConfig builds the client configuration from the options.
+func (o Options) restConfig() (*rest.Config, error) {
+	var cfg *rest.Config
 
-	home, _ := os.UserHomeDir()
-	kubeconfig := fmt.Sprintf("%s/.kube/config", home)
-	if env := os.Getenv("KUBECONFIG"); env != "" {
-		kubeconfig = env
+	if o.Kubeconfig == "" && o.Context == "" {
+		if inCluster, err := rest.InClusterConfig(); err == nil {
+			l.Debug("Using in-cluster configuration")
+			cfg = inCluster
+		}
 	}
 
-	loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}
-	configOverrides := &clientcmd.ConfigOverrides{}
-	flag.Parse()
+	if cfg == nil {
+		kubeconfig := o.Kubeconfig
+		if kubeconfig == "" {
+			kubeconfig = clientcmd.RecommendedHomeFile
+		}
+
+		loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}
+		configOverrides := &clientcmd.ConfigOverrides{CurrentContext: o.Context}
 
-	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
+		var err error
+		cfg, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %w", kubeconfig, err)
+		}
+		l.Debug("Using kubeconfig", "path", kubeconfig, "context", o.Context)
+	}
+
+	if o.QPS > 0 {
+		cfg.QPS = o.QPS
+	}
+	if o.Burst > 0 {
+		cfg.Burst = o.Burst
+	}
+	if o.UserAgent != "" {
+		cfg.UserAgent = o.UserAgent
+	}
+
+	impersonate, err := o.impersonationConfig()
 	if err != nil {
 		return nil, err
 	}
+	if impersonate.UserName != "" || len(impersonate.Groups) > 0 {
+		l.Info("Impersonating", "user", impersonate.UserName, "groups", impersonate.Groups)
+		cfg.Impersonate = impersonate
+	}
+
+	return cfg, nil
+}
+
+// impersonationConfig returns the user and groups to impersonate.
+func (o Options) impersonationConfig() (rest.ImpersonationConfig, error) {
+	config := rest.ImpersonationConfig{
+		UserName: o.ImpersonateUser,
+		Groups:   o.ImpersonateGroups,
+	}
 
-	client, err = kubernetes.NewForConfig(cfg)
+	// the API server only impersonates groups together with a user
+	if len(o.ImpersonateGroups) > 0 && o.ImpersonateUser == "" && o.ImpersonateServiceAccount == "" {
+		return config, fmt.Errorf("cannot impersonate groups %s without a user or service account", strings.Join(o.ImpersonateGroups, ","))
+	}
+
+	if o.ImpersonateServiceAccount == "" {
+		return config, nil
+	}
+
+	if o.ImpersonateUser != "" {
+		return config, fmt.Errorf("cannot impersonate user %s and service account %s at once", o.ImpersonateUser, o.ImpersonateServiceAccount)
+	}
+
+	namespace, name, ok := strings.Cut(o.ImpersonateServiceAccount, ":")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, ":") {
+		return config, fmt.Errorf("invalid service account %q to impersonate, expected namespace:name", o.ImpersonateServiceAccount)
+	}
+	config.UserName = fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+
+	return config, nil
+}
+
+// NewClient connects to the API server described by the options.
+func NewClient(ctx context.Context, options Options) (*Client, error) {
+	cfg, err := options.restConfig()
 	if err != nil {
 		return nil, err
 	}
 
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	metadataClient, err := metadata.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes metadata client: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes dynamic client: %w", err)
+	}
+
 	return &Client{
-		Ctx:    ctx,
-		Client: client,
+		Ctx:      ctx,
+		Client:   client,
+		Metadata: metadataClient,
+		Dynamic:  dynamicClient,
 	}, nil
 }
 
+// labelSelector builds the label selector for LABEL and LABEL_VALUE.
+func labelSelector(label string, labelValue string) string {
+	if labelValue == "" {
+		return label
+	}
+
+	return fmt.Sprintf("%s=%s", label, labelValue)
+}
+
 func (c *Client) GetConfigMaps(
 	namespaces []string,
 	label string,
 	labelValue string,
+	names *NameFilter,
 ) ([]corev1.ConfigMap, error) {
 
-	labelSelector := label
-
-	if labelValue != "" {
-		labelSelector = fmt.Sprintf("%s=%s", label, labelValue)
-	}
-
 	configMapOpt := metav1.ListOptions{
-		LabelSelector: labelSelector,
+		LabelSelector: labelSelector(label, labelValue),
+		FieldSelector: names.FieldSelector(),
 	}
 
 	var allConfigMaps []corev1.ConfigMap
@@ -107,23 +318,26 @@ func (c *Client) GetConfigMaps(
 		}
 	}
 
-	return allConfigMaps, nil
+	filtered := allConfigMaps[:0]
+	for _, configMap := range allConfigMaps {
+		if names.Match(configMap.Name) {
+			filtered = append(filtered, configMap)
+		}
+	}
+
+	return filtered, nil
 }
 
 func (c *Client) GetSecrets(
 	namespaces []string,
 	label string,
 	labelValue string,
+	names *NameFilter,
 ) ([]corev1.Secret, error) {
 
-	labelSelector := label
-
-	if labelValue != "" {
-		labelSelector = fmt.Sprintf("%s=%s", label, labelValue)
-	}
-
 	secretOpt := metav1.ListOptions{
-		LabelSelector: labelSelector,
+		LabelSelector: labelSelector(label, labelValue),
+		FieldSelector: names.FieldSelector(),
 	}
 
 	var allSecrets []corev1.Secret
@@ -146,312 +360,12 @@ func (c *Client) GetSecrets(
 		}
 	}
 
-	return allSecrets, nil
-}
-
-func (c *Client) ConfigMapInformerWorker(
-	namespaces []string,
-	label string,
-	labelValue string,
-	folder string,
-	folderAnnotation string,
-	writer writer.IWriter,
-	notifier notifier.INotifier,
-) {
-
-	// event driven worker
-	if len(namespaces) == 0 {
-		l.Debug("Start waiting for changes for all namespaces")
-		c.configMapInformerWorker(nil, label, labelValue, folder, folderAnnotation, writer, notifier)
-	} else {
-		for _, namespace := range namespaces {
-			l.Debug("Start waiting for changes for namespace:", "namespace", namespace)
-			c.configMapInformerWorker(&namespace, label, labelValue, folder, folderAnnotation, writer, notifier)
-		}
-	}
-
-	<-c.Ctx.Done()
-	c.Wg.Done()
-}
-
-func (c *Client) SecretInformerWorker(
-	namespaces []string,
-	label string,
-	labelValue string,
-	folder string,
-	folderAnnotation string,
-	writer writer.IWriter,
-	notifier notifier.INotifier,
-) {
-	if len(namespaces) == 0 {
-		l.Debug("Start waiting for changes for all namespaces")
-		c.secretInformerWorker(nil, label, labelValue, folder, folderAnnotation, writer, notifier)
-	} else {
-		for _, namespace := range namespaces {
-			l.Debug("Start waiting for changes for namespace:", "namespace", namespace)
-			c.secretInformerWorker(&namespace, label, labelValue, folder, folderAnnotation, writer, notifier)
-		}
-	}
-
-	<-c.Ctx.Done()
-	c.Wg.Done()
-}
-
-func (c *Client) matchesLabel(resourceLabels map[string]string,
-	expectedLabel string,
-	expectedLabelValue string) bool {
-
-	if expectedLabel == "" {
-		return true
-	}
-
-	for resourceLabel, resourceLabelValue := range resourceLabels {
-		if expectedLabelValue == "" && resourceLabel == expectedLabel {
-			return true
-		}
-
-		if resourceLabel == expectedLabel && resourceLabelValue == expectedLabelValue {
-			return true
+	filtered := allSecrets[:0]
+	for _, secret := range allSecrets {
+		if names.Match(secret.Name) {
+			filtered = append(filtered, secret)
 		}
 	}
 
-	return false
-}
-
-func (c *Client) configMapInformerWorker(
-	namespace *string,
-	label string,
-	labelValue string,
-	folder string,
-	folderAnnotation string,
-	writer writer.IWriter,
-	notifier notifier.INotifier,
-) {
-	rsync := 0 * time.Second
-	labelSelector := label
-	if labelValue != "" {
-		labelSelector = fmt.Sprintf("%s=%s", label, labelValue)
-	}
-
-	var factory informers.SharedInformerFactory
-
-	if namespace == nil {
-		factory = informers.NewSharedInformerFactoryWithOptions(
-			c.Client,
-			rsync,
-			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
-				options.LabelSelector = labelSelector
-			}),
-		)
-
-	} else {
-		factory = informers.NewSharedInformerFactoryWithOptions(
-			c.Client,
-			rsync,
-			informers.WithNamespace(*namespace),
-			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
-				options.LabelSelector = labelSelector
-			}),
-		)
-	}
-
-	cmInformer := factory.Core().V1().ConfigMaps().Informer()
-
-	cmInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			l.Debug("ConfigMap added:", "name", obj.(*corev1.ConfigMap).Name)
-			cm := obj.(*corev1.ConfigMap)
-
-			if !c.matchesLabel(cm.Labels, label, labelValue) {
-				l.Debug("ConfigMap does not match label:", "name", cm.Name, "label", label, "labelValue", labelValue)
-				return
-			}
-
-			for fileName, data := range cm.Data {
-				if !writer.IsJSON(fileName) {
-					l.Debug("ConfigMap file is not JSON:", "name", cm.Name, "fileName", fileName)
-					continue
-				}
-
-				folder := folder
-
-				if folderAnnotation != "" {
-					l.Debug("ConfigMap folder annotation:", "name", cm.Name, "folderAnnotation", folderAnnotation)
-					folder = path.Join(folder, cm.Annotations[folderAnnotation])
-				}
-
-				l.Debug("ConfigMap writing file:", "name", cm.Name, "fileName", fileName)
-				writer.Write(folder, fileName, data)
-			}
-			notifier.Notify()
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			cm := newObj.(*corev1.ConfigMap)
-
-			if !c.matchesLabel(cm.Labels, label, labelValue) {
-				l.Debug("ConfigMap does not match label:", "name", cm.Name, "label", label, "labelValue", labelValue)
-				return
-			}
-
-			for fileName, data := range cm.Data {
-				if !writer.IsJSON(fileName) {
-					l.Debug("ConfigMap file is not JSON:", "name", cm.Name, "fileName", fileName)
-					continue
-				}
-
-				folder := folder
-
-				if folderAnnotation != "" {
-					folder = path.Join(folder, cm.Annotations[folderAnnotation])
-				}
-
-				l.Debug("ConfigMap updating file:", "name", cm.Name, "fileName", fileName)
-				writer.Write(folder, fileName, data)
-			}
-		},
-		DeleteFunc: func(obj interface{}) {
-			cm := obj.(*corev1.ConfigMap)
-
-			if !c.matchesLabel(cm.Labels, label, labelValue) {
-				l.Debug("ConfigMap does not match label:", "name", cm.Name, "label", label, "labelValue", labelValue)
-				return
-			}
-
-			for fileName := range cm.Data {
-				if !writer.IsJSON(fileName) {
-					l.Debug("ConfigMap file is not JSON:", "name", cm.Name, "fileName", fileName)
-					continue
-				}
-
-				folder := folder
-
-				if folderAnnotation != "" {
-					folder = path.Join(folder, cm.Annotations[folderAnnotation])
-				}
-
-				l.Debug("ConfigMap removing file:", "name", cm.Name, "fileName", fileName)
-				writer.Remove(folder, fileName)
-			}
-		},
-	})
-
-	factory.Start(c.Ctx.Done())
-	factory.WaitForCacheSync(c.Ctx.Done())
-}
-
-func (c *Client) secretInformerWorker(
-	namespace *string,
-	label string,
-	labelValue string,
-	folder string,
-	folderAnnotation string,
-	writer writer.IWriter,
-	notifier notifier.INotifier,
-) {
-	rsync := 0 * time.Second
-	labelSelector := label
-	if labelValue != "" {
-		labelSelector = fmt.Sprintf("%s=%s", label, labelValue)
-	}
-
-	var factory informers.SharedInformerFactory
-
-	if namespace == nil {
-		factory = informers.NewSharedInformerFactoryWithOptions(
-			c.Client,
-			rsync,
-			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
-				options.LabelSelector = labelSelector
-			}),
-		)
-	} else {
-		factory = informers.NewSharedInformerFactoryWithOptions(
-			c.Client,
-			rsync,
-			informers.WithNamespace(*namespace),
-			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
-				options.LabelSelector = labelSelector
-			}),
-		)
-	}
-
-	secretInformer := factory.Core().V1().Secrets().Informer()
-
-	secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			secret := obj.(*corev1.Secret)
-			if !c.matchesLabel(secret.Labels, label, labelValue) {
-				l.Debug("Secret does not match label:", "name", secret.Name, "label", label, "labelValue", labelValue)
-				return
-			}
-
-			for fileName, data := range secret.Data {
-				if !writer.IsJSON(fileName) {
-					l.Debug("Secret file is not JSON:", "name", secret.Name, "fileName", fileName)
-					continue
-				}
-
-				folder := folder
-
-				if folderAnnotation != "" {
-					l.Debug("Secret folder annotation:", "name", secret.Name, "folderAnnotation", folderAnnotation)
-					folder = path.Join(folder, secret.Annotations[folderAnnotation])
-				}
-
-				l.Debug("Secret writing file:", "name", secret.Name, "fileName", fileName)
-				writer.Write(folder, fileName, string(data))
-			}
-			notifier.Notify()
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			secret := newObj.(*corev1.Secret)
-			if !c.matchesLabel(secret.Labels, label, labelValue) {
-				l.Debug("Secret does not match label:", "name", secret.Name, "label", label, "labelValue", labelValue)
-				return
-			}
-
-			for fileName, data := range secret.Data {
-				if !writer.IsJSON(fileName) {
-					l.Debug("Secret file is not JSON:", "name", secret.Name, "fileName", fileName)
-					continue
-				}
-
-				folder := folder
-
-				if folderAnnotation != "" {
-					l.Debug("Secret folder annotation:", "name", secret.Name, "folderAnnotation", folderAnnotation)
-					folder = path.Join(folder, secret.Annotations[folderAnnotation])
-				}
-
-				l.Debug("Secret updating file:", "name", secret.Name, "fileName", fileName)
-				writer.Write(folder, fileName, string(data))
-			}
-		},
-		DeleteFunc: func(obj interface{}) {
-			secret := obj.(*corev1.Secret)
-			if !c.matchesLabel(secret.Labels, label, labelValue) {
-				l.Debug("Secret does not match label:", "name", secret.Name, "label", label, "labelValue", labelValue)
-				return
-			}
-			for fileName := range secret.Data {
-				if !writer.IsJSON(fileName) {
-					l.Debug("Secret file is not JSON:", "name", secret.Name, "fileName", fileName)
-					continue
-				}
-
-				folder := folder
-
-				if folderAnnotation != "" {
-					l.Debug("Secret folder annotation:", "name", secret.Name, "folderAnnotation", folderAnnotation)
-					folder = path.Join(folder, secret.Annotations[folderAnnotation])
-				}
-
-				l.Debug("Secret removing file:", "name", secret.Name, "fileName", fileName)
-				writer.Remove(folder, fileName)
-			}
-		},
-	})
-
-	factory.Start(c.Ctx.Done())
-	factory.WaitForCacheSync(c.Ctx.Done())
+	return filtered, nil
 }
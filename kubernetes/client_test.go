@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+- name: prod
+  context:
+    cluster: prod
+current-context: dev
+`
+
+func TestOptions_RestConfig(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(testKubeconfig), 0644); err != nil {
+		t.Fatalf("Failed to write kubeconfig: %v", err)
+	}
+
+	cfg, err := Options{Kubeconfig: kubeconfig}.restConfig()
+	if err != nil {
+		t.Fatalf("Failed to load kubeconfig: %v", err)
+	}
+	if cfg.Host != "https://dev.example.com:6443" {
+		t.Errorf("Expected the current context to be used, got host %s", cfg.Host)
+	}
+
+	cfg, err = Options{
+		Kubeconfig: kubeconfig,
+		Context:    "prod",
+		QPS:        50,
+		Burst:      100,
+		UserAgent:  "k8s-gsidecar/test",
+	}.restConfig()
+	if err != nil {
+		t.Fatalf("Failed to load kubeconfig: %v", err)
+	}
+	if cfg.Host != "https://prod.example.com:6443" {
+		t.Errorf("Expected the prod context to be used, got host %s", cfg.Host)
+	}
+	if cfg.QPS != 50 || cfg.Burst != 100 || cfg.UserAgent != "k8s-gsidecar/test" {
+		t.Errorf("Expected QPS 50, Burst 100 and the user agent to be set, got %v, %v, %q", cfg.QPS, cfg.Burst, cfg.UserAgent)
+	}
+
+	if _, err := (Options{Kubeconfig: kubeconfig, Context: "missing"}).restConfig(); err == nil {
+		t.Error("Expected an unknown context to fail")
+	}
+	if _, err := (Options{Kubeconfig: filepath.Join(t.TempDir(), "missing")}).restConfig(); err == nil {
+		t.Error("Expected a missing kubeconfig to fail")
+	}
+}
+
+func TestOptions_Impersonation(t *testing.T) {
+	config, err := Options{ImpersonateServiceAccount: "monitoring:grafana"}.impersonationConfig()
+	if err != nil {
+		t.Fatalf("Failed to build impersonation config: %v", err)
+	}
+	if config.UserName != "system:serviceaccount:monitoring:grafana" {
+		t.Errorf("Expected the service account user name, got %s", config.UserName)
+	}
+
+	config, err = Options{ImpersonateUser: "jane", ImpersonateGroups: []string{"dev"}}.impersonationConfig()
+	if err != nil {
+		t.Fatalf("Failed to build impersonation config: %v", err)
+	}
+	if config.UserName != "jane" || len(config.Groups) != 1 || config.Groups[0] != "dev" {
+		t.Errorf("Expected to impersonate jane in group dev, got %+v", config)
+	}
+
+	for _, options := range []Options{
+		{ImpersonateServiceAccount: "grafana"},
+		{ImpersonateServiceAccount: "monitoring:"},
+		{ImpersonateUser: "jane", ImpersonateServiceAccount: "monitoring:grafana"},
+		{ImpersonateGroups: []string{"dev"}},
+	} {
+		if _, err := options.impersonationConfig(); err == nil {
+			t.Errorf("Expected %+v to fail", options)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// CustomResource describes an arbitrary resource, e.g. a CRD, watched through
+// the dynamic client. Every object contributes one file whose name and
+// content are extracted with JSONPath templates.
+type CustomResource struct {
+	GVR      schema.GroupVersionResource
+	fileName *jsonpath.JSONPath
+	content  *jsonpath.JSONPath
+}
+
+// NewCustomResource parses the resource as group/version/resource (or
+// version/resource for the core group) and the JSONPath templates used for
+// the file name and content, e.g. "{.metadata.name}.json" and "{.spec.json}".
+func NewCustomResource(resource string, fileName string, content string) (*CustomResource, error) {
+	gvr, err := parseGroupVersionResource(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if fileName == "" || content == "" {
+		return nil, fmt.Errorf("custom resource %q needs a file name and a content JSONPath", resource)
+	}
+
+	fileNamePath := jsonpath.New("filename")
+	if err := fileNamePath.Parse(fileName); err != nil {
+		return nil, fmt.Errorf("invalid file name JSONPath %q: %w", fileName, err)
+	}
+
+	contentPath := jsonpath.New("content")
+	if err := contentPath.Parse(content); err != nil {
+		return nil, fmt.Errorf("invalid content JSONPath %q: %w", content, err)
+	}
+
+	return &CustomResource{
+		GVR:      gvr,
+		fileName: fileNamePath,
+		content:  contentPath,
+	}, nil
+}
+
+func parseGroupVersionResource(resource string) (schema.GroupVersionResource, error) {
+	parts := strings.Split(resource, "/")
+
+	switch {
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}, nil
+	case len(parts) == 3 && parts[0] != "" && parts[1] != "" && parts[2] != "":
+		return schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}, nil
+	}
+
+	return schema.GroupVersionResource{}, fmt.Errorf("invalid custom resource %q, expected group/version/resource", resource)
+}
+
+// Files extracts the file the object contributes. Content that is an object
+// or a list is written as JSON.
+func (r *CustomResource) Files(obj *unstructured.Unstructured) (map[string]string, error) {
+	fileName, err := execute(r.fileName, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract file name: %w", err)
+	}
+
+	if fileName == "" {
+		return nil, fmt.Errorf("empty file name")
+	}
+	// the file name comes from the object, it must not leave the folder
+	if strings.ContainsAny(fileName, `/\`) || fileName == "." || fileName == ".." {
+		return nil, fmt.Errorf("invalid file name %q, must not contain a path", fileName)
+	}
+
+	content, err := execute(r.content, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract content: %w", err)
+	}
+
+	return map[string]string{fileName: content}, nil
+}
+
+func execute(path *jsonpath.JSONPath, obj *unstructured.Unstructured) (string, error) {
+	var buf bytes.Buffer
+	if err := path.Execute(&buf, obj.Object); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (c *Client) GetCustomResources(
+	custom *CustomResource,
+	namespaces []string,
+	label string,
+	labelValue string,
+	names *NameFilter,
+) ([]unstructured.Unstructured, error) {
+
+	listOpt := metav1.ListOptions{
+		LabelSelector: labelSelector(label, labelValue),
+		FieldSelector: names.FieldSelector(),
+	}
+
+	var allResources []unstructured.Unstructured
+
+	if len(namespaces) == 0 {
+		l.Debug("Getting all custom resources", "resource", custom.GVR.String())
+		resources, err := c.Dynamic.Resource(custom.GVR).Namespace(metav1.NamespaceAll).List(c.Ctx, listOpt)
+		if err != nil {
+			return nil, err
+		}
+		allResources = append(allResources, resources.Items...)
+	} else {
+		l.Debug("Getting custom resources for namespaces", "resource", custom.GVR.String(), "namespaces", namespaces)
+		for _, namespace := range namespaces {
+			resources, err := c.Dynamic.Resource(custom.GVR).Namespace(namespace).List(c.Ctx, listOpt)
+			if err != nil {
+				return nil, err
+			}
+			allResources = append(allResources, resources.Items...)
+		}
+	}
+
+	filtered := allResources[:0]
+	for _, resource := range allResources {
+		if names.Match(resource.GetName()) {
+			filtered = append(filtered, resource)
+		}
+	}
+
+	return filtered, nil
+}
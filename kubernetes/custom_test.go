@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCustomResource_Files(t *testing.T) {
+	custom, err := NewCustomResource("grafana.integreatly.org/v1beta1/grafanadashboards", "{.spec.title}.json", "{.spec.json}")
+	if err != nil {
+		t.Fatalf("Failed to create custom resource: %v", err)
+	}
+
+	newObject := func(title string, content interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"title": title, "json": content},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		obj      *unstructured.Unstructured
+		fileName string
+		content  string
+		err      string
+	}{
+		{
+			name:     "string content",
+			obj:      newObject("overview", `{"title": "Overview"}`),
+			fileName: "overview.json",
+			content:  `{"title": "Overview"}`,
+		},
+		{
+			name:     "object content",
+			obj:      newObject("overview", map[string]interface{}{"title": "Overview", "panels": []interface{}{}}),
+			fileName: "overview.json",
+			content:  `{"panels":[],"title":"Overview"}`,
+		},
+		{
+			name: "path traversal",
+			obj:  newObject("../../etc/overview", `{}`),
+			err:  `invalid file name "../../etc/overview.json"`,
+		},
+		{
+			name: "missing file name",
+			obj:  &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{"json": `{}`}}},
+			err:  "failed to extract file name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := custom.Files(tt.obj)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to extract files: %v", err)
+			}
+			if content, ok := files[tt.fileName]; !ok || content != tt.content {
+				t.Errorf("Expected %s with %s, got %v", tt.fileName, tt.content, files)
+			}
+		})
+	}
+}
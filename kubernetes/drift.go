@@ -0,0 +1,143 @@
+package kubernetes
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// driftBaseDelay is how long a changed file is left alone before it is
+	// repaired, the delay doubles for every repair of the same resource up
+	// to driftMaxDelay, so a fight with another writer slows down quickly.
+	driftBaseDelay = 100 * time.Millisecond
+	driftMaxDelay  = 5 * time.Minute
+	// driftQuietPeriod resets the delay of a resource that was not repaired
+	// for this long.
+	driftQuietPeriod = 10 * time.Minute
+)
+
+// driftWatcher watches the folders a handler writes to and writes files
+// that were changed or deleted by someone else again from the informer
+// cache.
+type driftWatcher struct {
+	h       *handler
+	watcher *fsnotify.Watcher
+	queue   workqueue.TypedRateLimitingInterface[string]
+
+	mu          sync.Mutex
+	folders     map[string]struct{}
+	lastRepairs map[string]time.Time
+}
+
+func newDriftWatcher(h *handler) (*driftWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &driftWatcher{
+		h:       h,
+		watcher: watcher,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[string](driftBaseDelay, driftMaxDelay),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "drift"},
+		),
+		folders:     map[string]struct{}{},
+		lastRepairs: map[string]time.Time{},
+	}
+
+	go d.watch()
+	h.client.handlers.Add(1)
+	go d.repair()
+
+	// repairs already queued are still done when shutting down
+	go func() {
+		<-h.client.Ctx.Done()
+		d.watcher.Close()
+		d.queue.ShutDownWithDrain()
+	}()
+
+	return d, nil
+}
+
+// add starts watching the folder if it is not watched yet.
+func (d *driftWatcher) add(folder string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	folder = filepath.Clean(folder)
+	if _, ok := d.folders[folder]; ok {
+		return
+	}
+
+	if err := d.watcher.Add(folder); err != nil {
+		l.Error("Failed to watch folder for local changes:", "folder", folder, "error", err)
+		return
+	}
+	d.folders[folder] = struct{}{}
+}
+
+// watch queues the resource owning a changed file for repair.
+func (d *driftWatcher) watch() {
+	for {
+		select {
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Remove) &&
+				!event.Has(fsnotify.Rename) && !event.Has(fsnotify.Create) {
+				continue
+			}
+
+			key, ok := d.h.files.Owner(filepath.Dir(event.Name), filepath.Base(event.Name))
+			if !ok {
+				continue
+			}
+
+			d.mu.Lock()
+			if time.Since(d.lastRepairs[key]) > driftQuietPeriod {
+				d.queue.Forget(key)
+			}
+			d.mu.Unlock()
+
+			l.Debug("Managed file changed locally:", "file", event.Name, "op", event.Op.String(), "resource", key)
+			d.queue.AddRateLimited(key)
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			l.Error("Failed to watch local changes:", "error", err)
+		}
+	}
+}
+
+// repair writes the files of queued resources again. Writing unchanged
+// files is skipped by the writer, so the events of our own writes are cheap.
+func (d *driftWatcher) repair() {
+	defer d.h.client.handlers.Done()
+
+	for {
+		key, shutdown := d.queue.Get()
+		if shutdown {
+			return
+		}
+
+		if res, written := d.h.repair(key); written > 0 {
+			l.Info("Repaired local changes:", "resource", key, "files", written)
+
+			d.mu.Lock()
+			d.lastRepairs[key] = time.Now()
+			d.mu.Unlock()
+
+			d.h.notify(res)
+		}
+
+		d.queue.Done(key)
+	}
+}
@@ -0,0 +1,51 @@
+package kubernetes
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the Events recorded on synced resources.
+const (
+	ReasonSynced         = "Synced"
+	ReasonWriteFailed    = "WriteFailed"
+	ReasonInvalidContent = "InvalidContent"
+	ReasonNotifyFailed   = "NotifyFailed"
+)
+
+const (
+	// eventBurst and eventQPS limit the Events recorded per resource, a
+	// resource failing on every resync does not flood its namespace.
+	eventBurst = 10
+	eventQPS   = 1. / 60
+)
+
+// NewEventRecorder returns a recorder sending Events to the API server until
+// the client context is done.
+func (c *Client) NewEventRecorder(component string, host string) record.EventRecorder {
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: eventBurst,
+		QPS:       eventQPS,
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.Client.CoreV1().Events("")})
+
+	ctx := c.Ctx
+	go func() {
+		<-ctx.Done()
+		broadcaster.Shutdown()
+	}()
+
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component, Host: host})
+}
+
+// Event records an Event on obj when Events are enabled and obj is known.
+func (c *Client) Event(obj runtime.Object, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if c.Events == nil || obj == nil {
+		return
+	}
+
+	c.Events.Eventf(obj, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,61 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewEventRecorder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset()
+	c := &Client{Ctx: ctx, Client: fakeClientset}
+	c.Events = c.NewEventRecorder("k8s-gsidecar", "sidecar-0")
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dashboard",
+			Namespace: "monitoring",
+			UID:       "1234",
+		},
+	}
+	c.Event(configMap, corev1.EventTypeWarning, ReasonWriteFailed, "Failed to write %s", "dashboard.json")
+
+	var events *corev1.EventList
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var err error
+		events, err = fakeClientset.CoreV1().Events("monitoring").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Failed to list events: %v", err)
+		}
+		if len(events.Items) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if len(events.Items) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events.Items))
+	}
+
+	event := events.Items[0]
+	if event.InvolvedObject.Kind != KindConfigMap || event.InvolvedObject.Name != "dashboard" {
+		t.Errorf("Expected the event to involve ConfigMap dashboard, got %+v", event.InvolvedObject)
+	}
+	if event.Type != corev1.EventTypeWarning || event.Reason != ReasonWriteFailed || event.Message != "Failed to write dashboard.json" {
+		t.Errorf("Expected a WriteFailed warning, got %s %s %q", event.Type, event.Reason, event.Message)
+	}
+	if event.Source.Component != "k8s-gsidecar" || event.Source.Host != "sidecar-0" {
+		t.Errorf("Expected the sidecar as source, got %+v", event.Source)
+	}
+
+	// without a recorder Events are dropped
+	(&Client{}).Event(configMap, corev1.EventTypeNormal, ReasonSynced, "Wrote files")
+}
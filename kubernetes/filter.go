@@ -0,0 +1,85 @@
+package kubernetes
+
+import (
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// NameFilter restricts synced resources to a set of names. Entries containing
+// glob meta characters (*, ? or [) are matched with path.Match, every other
+// entry has to match the resource name exactly.
+//
+// A nil *NameFilter matches every name.
+type NameFilter struct {
+	names    map[string]struct{}
+	patterns []string
+}
+
+// NewNameFilter builds a filter from a list of names and glob patterns. Empty
+// entries are ignored and nil is returned when no entry is left. Malformed
+// patterns are treated as exact names.
+func NewNameFilter(entries []string) *NameFilter {
+	f := &NameFilter{
+		names: map[string]struct{}{},
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.ContainsAny(entry, "*?[") {
+			if _, err := path.Match(entry, ""); err == nil {
+				f.patterns = append(f.patterns, entry)
+				continue
+			}
+			l.Warn("Invalid resource name pattern, matching it literally", "pattern", entry)
+		}
+
+		f.names[entry] = struct{}{}
+	}
+
+	if len(f.names) == 0 && len(f.patterns) == 0 {
+		return nil
+	}
+
+	return f
+}
+
+// Match reports whether the resource name passes the filter.
+func (f *NameFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	if _, ok := f.names[name]; ok {
+		return true
+	}
+
+	for _, pattern := range f.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FieldSelector returns a server side field selector for the filter. The API
+// server can only match a single metadata.name, so an empty selector is
+// returned whenever the filter holds several names or any pattern and the
+// filtering has to happen client side.
+func (f *NameFilter) FieldSelector() string {
+	if f == nil || len(f.names) != 1 || len(f.patterns) != 0 {
+		return ""
+	}
+
+	for name := range f.names {
+		return fields.OneTermEqualSelector("metadata.name", name).String()
+	}
+
+	return ""
+}
@@ -0,0 +1,478 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"k8s-gsidecar/metrics"
+	"k8s-gsidecar/notifier"
+	"k8s-gsidecar/state"
+	"k8s-gsidecar/writer"
+	"path"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func matchesLabel(resourceLabels map[string]string,
+	expectedLabel string,
+	expectedLabelValue string) bool {
+
+	if expectedLabel == "" {
+		return true
+	}
+
+	for resourceLabel, resourceLabelValue := range resourceLabels {
+		if expectedLabelValue == "" && resourceLabel == expectedLabel {
+			return true
+		}
+
+		if resourceLabel == expectedLabel && resourceLabelValue == expectedLabelValue {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resource is a ConfigMap or Secret reduced to what the handlers need. When
+// it comes from a metadata-only informer, data is nil and metadataOnly is set.
+type resource struct {
+	kind         string
+	meta         metav1.Object
+	data         map[string]string
+	metadataOnly bool
+}
+
+func (r *resource) key() string {
+	return state.Key(r.kind, r.meta.GetNamespace(), r.meta.GetName())
+}
+
+// object returns the API object Events are recorded on.
+func (r *resource) object() runtime.Object {
+	obj, _ := r.meta.(runtime.Object)
+	return obj
+}
+
+// toResource converts an informer object, unwrapping tombstones of deleted
+// objects. kind is only used for metadata-only objects, which do not carry
+// their own kind.
+func (h *handler) toResource(kind string, obj interface{}) (*resource, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	switch object := obj.(type) {
+	case *corev1.ConfigMap:
+		return &resource{kind: KindConfigMap, meta: object, data: object.Data}, true
+	case *corev1.Secret:
+		// Secret.Data is []byte, convert to string
+		data := make(map[string]string, len(object.Data))
+		for fileName, value := range object.Data {
+			data[fileName] = string(value)
+		}
+		return &resource{kind: KindSecret, meta: object, data: data}, true
+	case *metav1.PartialObjectMetadata:
+		return &resource{kind: kind, meta: object, metadataOnly: true}, true
+	case *unstructured.Unstructured:
+		if h.custom == nil {
+			return nil, false
+		}
+
+		// files of objects the templates fail on are removed once the
+		// handler records that they have none
+		data, err := h.custom.Files(object)
+		if err != nil {
+			l.Error("Failed to extract file from "+object.GetKind()+":", "namespace", object.GetNamespace(), "name", object.GetName(), "error", err)
+			h.client.Event(object, corev1.EventTypeWarning, ReasonInvalidContent, "Failed to extract file: %v", err)
+		}
+		return &resource{kind: object.GetKind(), meta: object, data: data}, true
+	}
+
+	return nil, false
+}
+
+// handler writes the files of matching ConfigMaps and Secrets. A single
+// handler per profile is registered on a shared informer, filtering by
+// namespace, label and name happens here.
+type handler struct {
+	client *Client
+	custom *CustomResource
+	files  *state.Store
+	// skipProcessed is set when files is the store of processed resources
+	skipProcessed    bool
+	namespaces       *namespaceFilter
+	label            string
+	labelValue       string
+	names            *NameFilter
+	folder           string
+	folderAnnotation string
+	writer           writer.IWriter
+	notifier         notifier.INotifier
+	// drift is set when local changes to written files are repaired
+	drift *driftWatcher
+	// queue holds the keys of the resources to reconcile
+	queue workqueue.TypedRateLimitingInterface[string]
+
+	mu        sync.Mutex
+	informers []cache.SharedIndexInformer
+	// pending holds what the informer events tell about queued resources
+	// beyond their key
+	pending map[string]pendingEvent
+	// unsynced holds the keys of queued resources that were not reconciled
+	// without a failure yet
+	unsynced map[string]struct{}
+}
+
+// addInformer registers an informer whose cache is used to repair files.
+func (h *handler) addInformer(informer cache.SharedIndexInformer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.informers = append(h.informers, informer)
+}
+
+func (h *handler) matches(res *resource) bool {
+	if !h.namespaces.Match(res.meta.GetNamespace()) {
+		return false
+	}
+
+	return h.selects(res)
+}
+
+// selects checks label and name, ignoring the namespace selection.
+func (h *handler) selects(res *resource) bool {
+	if !matchesLabel(res.meta.GetLabels(), h.label, h.labelValue) {
+		l.Debug(res.kind+" does not match label:", "name", res.meta.GetName(), "label", h.label, "labelValue", h.labelValue)
+		return false
+	}
+
+	if !h.names.Match(res.meta.GetName()) {
+		l.Debug(res.kind+" does not match resource name:", "name", res.meta.GetName())
+		return false
+	}
+
+	return true
+}
+
+// load fetches the content of a metadata-only resource from the API server.
+// It returns nil when the resource is gone or no longer matches.
+func (h *handler) load(res *resource) (*resource, error) {
+	if !res.metadataOnly {
+		return res, nil
+	}
+
+	var obj interface{}
+	var err error
+	switch res.kind {
+	case KindSecret:
+		obj, err = h.client.Client.CoreV1().Secrets(res.meta.GetNamespace()).Get(h.client.Ctx, res.meta.GetName(), metav1.GetOptions{})
+	default:
+		l.Error("Metadata-only watch is not supported:", "kind", res.kind)
+		return nil, nil
+	}
+
+	if errors.IsNotFound(err) {
+		l.Debug(res.kind+" is gone:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s/%s: %w", res.kind, res.meta.GetNamespace(), res.meta.GetName(), err)
+	}
+
+	full, ok := h.toResource(res.kind, obj)
+	if !ok || !h.selects(full) {
+		return nil, nil
+	}
+
+	return full, nil
+}
+
+func (h *handler) targetFolder(obj metav1.Object) string {
+	if h.folderAnnotation == "" {
+		return h.folder
+	}
+
+	return path.Join(h.folder, obj.GetAnnotations()[h.folderAnnotation])
+}
+
+// jsonFiles returns the JSON files of the resource.
+func (h *handler) jsonFiles(res *resource) map[string]string {
+	files := make(map[string]string, len(res.data))
+	for fileName, content := range res.data {
+		if !h.writer.IsJSON(fileName) {
+			l.Debug(res.kind+" file is not JSON:", "name", res.meta.GetName(), "fileName", fileName)
+			continue
+		}
+		files[fileName] = content
+	}
+
+	return files
+}
+
+// processed reports whether the resource was already processed with its
+// current resourceVersion. Resources are only skipped when the handler keeps
+// state.
+func (h *handler) processed(res *resource) bool {
+	if !h.skipProcessed {
+		return false
+	}
+
+	if !h.files.Processed(res.key(), res.meta.GetResourceVersion()) {
+		return false
+	}
+
+	l.Debug(res.kind+" already processed:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName(), "resourceVersion", res.meta.GetResourceVersion())
+	return true
+}
+
+// unchanged is like processed, but also recognizes a new resourceVersion
+// with the same content.
+func (h *handler) unchanged(res *resource) bool {
+	if !h.skipProcessed {
+		return false
+	}
+
+	hash := state.Hash(h.targetFolder(res.meta), h.jsonFiles(res))
+	if !h.files.Unchanged(res.key(), res.meta.GetResourceVersion(), hash) {
+		return false
+	}
+
+	l.Debug(res.kind+" content unchanged:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName(), "resourceVersion", res.meta.GetResourceVersion())
+	return true
+}
+
+// writeFiles writes every JSON file of the resource, removes the files it
+// no longer has and returns how many files actually changed. Every file is
+// attempted, the last write error is returned.
+func (h *handler) writeFiles(res *resource) (int, error) {
+	written := 0
+	failed := 0
+	var lastErr error
+	folder := h.targetFolder(res.meta)
+	data := h.jsonFiles(res)
+	var files []state.File
+
+	for fileName, content := range data {
+		files = append(files, state.File{Folder: folder, Name: fileName})
+		if !json.Valid([]byte(content)) {
+			h.client.Event(res.object(), corev1.EventTypeWarning, ReasonInvalidContent, "%s is not valid JSON", fileName)
+		}
+
+		changed, err := h.writer.Write(folder, fileName, content)
+		if err != nil {
+			l.Error("Failed to write file:", "name", res.meta.GetName(), "fileName", fileName, "error", err)
+			h.client.Event(res.object(), corev1.EventTypeWarning, ReasonWriteFailed, "Failed to write %s: %v", path.Join(folder, fileName), err)
+			failed++
+			lastErr = err
+			continue
+		}
+		if !changed {
+			l.Debug(res.kind+" file unchanged:", "name", res.meta.GetName(), "fileName", fileName)
+			continue
+		}
+		l.Debug(res.kind+" wrote file:", "name", res.meta.GetName(), "fileName", fileName)
+		written++
+	}
+
+	// the files are recorded to remove them later, but a resource that
+	// failed is not processed: its retry must not be skipped
+	entry := state.Entry{Files: files}
+	if failed == 0 {
+		entry.ResourceVersion = res.meta.GetResourceVersion()
+		entry.Hash = state.Hash(folder, data)
+	}
+	// files the resource no longer has, e.g. after a key or folder was
+	// renamed, are removed
+	written += h.remove(res, h.files.Set(res.key(), entry))
+
+	if h.drift != nil && len(files) > 0 {
+		h.drift.add(folder)
+	}
+
+	if written > 0 {
+		h.client.Event(res.object(), corev1.EventTypeNormal, ReasonSynced, "Wrote %d files to %s", written, folder)
+	}
+
+	result := ReasonSynced
+	if failed > 0 {
+		result = ReasonWriteFailed
+	} else {
+		metrics.LastSync.SetToCurrentTime()
+	}
+	h.client.ReportSyncStatus(res.kind, res.meta, h.custom, written, result)
+
+	return written, lastErr
+}
+
+// notify calls the notifier after files of the resources changed. A failure
+// is recorded on each of them. Without a notifier there is nothing to do.
+func (h *handler) notify(changed ...*resource) {
+	if h.notifier == nil {
+		return
+	}
+
+	err := h.notifier.Notify()
+	if err == nil {
+		return
+	}
+
+	l.Error("Failed to notify:", "error", err)
+	for _, res := range changed {
+		h.client.Event(res.object(), corev1.EventTypeWarning, ReasonNotifyFailed, "Failed to notify: %v", err)
+	}
+}
+
+// cached returns the resource with the key from the informer caches.
+func (h *handler) cached(key string) (*resource, bool) {
+	kind, namespace, name := state.SplitKey(key)
+
+	cacheKey := name
+	if namespace != "" {
+		cacheKey = namespace + "/" + name
+	}
+
+	h.mu.Lock()
+	informers := append([]cache.SharedIndexInformer(nil), h.informers...)
+	h.mu.Unlock()
+
+	for _, informer := range informers {
+		obj, exists, err := informer.GetIndexer().GetByKey(cacheKey)
+		if err != nil || !exists {
+			continue
+		}
+
+		res, ok := h.toResource(kind, obj)
+		if ok && res.key() == key {
+			return res, true
+		}
+	}
+
+	return nil, false
+}
+
+// repair writes the files of the resource again from the informer cache and
+// returns it together with how many files had to be written.
+func (h *handler) repair(key string) (*resource, int) {
+	res, ok := h.cached(key)
+	if !ok || !h.matches(res) {
+		return nil, 0
+	}
+
+	res, err := h.load(res)
+	if err != nil {
+		l.Error("Failed to repair:", "resource", key, "error", err)
+		return nil, 0
+	}
+	if res == nil {
+		return nil, 0
+	}
+
+	written, _ := h.writeFiles(res)
+	return res, written
+}
+
+// removeFiles removes every JSON file the resource contributed and returns
+// how many files were removed. The files recorded when the resource was
+// written are preferred, the resource data is only used when nothing was
+// recorded.
+func (h *handler) removeFiles(res *resource) int {
+	files := h.files.Delete(res.key())
+
+	if len(files) == 0 {
+		folder := h.targetFolder(res.meta)
+		for fileName := range h.jsonFiles(res) {
+			files = append(files, state.File{Folder: folder, Name: fileName})
+		}
+	}
+
+	return h.remove(res, files)
+}
+
+// remove removes the files of the resource and returns how many were
+// removed.
+func (h *handler) remove(res *resource, files []state.File) int {
+	removed := 0
+	for _, file := range files {
+		l.Debug(res.kind+" removing file:", "name", res.meta.GetName(), "fileName", file.Name)
+		if err := h.writer.Remove(file.Folder, file.Name); err != nil {
+			l.Error("Failed to remove file:", "name", res.meta.GetName(), "fileName", file.Name, "error", err)
+			continue
+		}
+		removed++
+	}
+
+	return removed
+}
+
+// eventHandler queues the keys of the matching resources the informer
+// reports, the queue workers write their files.
+func (h *handler) eventHandler(kind string) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			res, ok := h.toResource(kind, obj)
+			if !ok {
+				return
+			}
+			metrics.Events.WithLabelValues(res.kind, "add").Inc()
+			if !h.matches(res) {
+				return
+			}
+
+			l.Debug(res.kind+" added:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName())
+			h.enqueue(res, pendingEvent{})
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			res, ok := h.toResource(kind, newObj)
+			if !ok {
+				return
+			}
+			metrics.Events.WithLabelValues(res.kind, "update").Inc()
+			old, ok := h.toResource(kind, oldObj)
+			if !h.matches(res) {
+				// the API server usually reports a resource whose labels stop
+				// matching the selector as deleted, when it is still cached
+				// its files are removed the same way
+				if ok && h.matches(old) {
+					l.Debug(res.kind+" no longer matches:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName())
+					h.enqueue(res, pendingEvent{})
+				}
+				return
+			}
+
+			// a resync replays unchanged objects, their files are written again
+			// to repair local drift and the writer skips files still in place
+			resync := ok && old.meta.GetResourceVersion() == res.meta.GetResourceVersion()
+
+			if !resync && ok && statusOnly(old, res) {
+				l.Debug(res.kind+" status annotation updated:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName())
+				return
+			}
+
+			if resync {
+				l.Debug(res.kind+" resync:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName())
+			} else {
+				l.Debug(res.kind+" updated:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName())
+			}
+			h.enqueue(res, pendingEvent{resync: resync})
+		},
+		DeleteFunc: func(obj interface{}) {
+			res, ok := h.toResource(kind, obj)
+			if !ok {
+				return
+			}
+			metrics.Events.WithLabelValues(res.kind, "delete").Inc()
+			if !h.matches(res) {
+				return
+			}
+
+			l.Debug(res.kind+" deleted:", "namespace", res.meta.GetNamespace(), "name", res.meta.GetName())
+			h.enqueue(res, pendingEvent{deleted: res})
+		},
+	}
+}
@@ -0,0 +1,440 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"k8s-gsidecar/metrics"
+	"k8s-gsidecar/notifier"
+	"k8s-gsidecar/state"
+	"k8s-gsidecar/writer"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	KindConfigMap = "ConfigMap"
+	KindSecret    = "Secret"
+)
+
+// factoryKey identifies a shared informer factory. Informers for different
+// resources watched with the same scope and selectors share one factory.
+type factoryKey struct {
+	namespace     string
+	labelSelector string
+	fieldSelector string
+}
+
+// tweakListOptions applies the selectors and the server side watch timeout
+// to the list and watch requests of an informer.
+func (c *Client) tweakListOptions(labelSelector string, fieldSelector string) func(options *metav1.ListOptions) {
+	return func(options *metav1.ListOptions) {
+		options.LabelSelector = labelSelector
+		options.FieldSelector = fieldSelector
+
+		if c.WatchTimeout > 0 {
+			timeoutSeconds := int64(c.WatchTimeout.Seconds())
+			options.TimeoutSeconds = &timeoutSeconds
+		}
+	}
+}
+
+// factory returns the shared informer factory for the given scope and
+// selectors, creating it on first use.
+func (c *Client) factory(namespace string, labelSelector string, fieldSelector string) informers.SharedInformerFactory {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := factoryKey{
+		namespace:     namespace,
+		labelSelector: labelSelector,
+		fieldSelector: fieldSelector,
+	}
+
+	if factory, ok := c.factories[key]; ok {
+		return factory
+	}
+
+	options := []informers.SharedInformerOption{
+		informers.WithTweakListOptions(c.tweakListOptions(labelSelector, fieldSelector)),
+	}
+
+	if namespace != metav1.NamespaceAll {
+		options = append(options, informers.WithNamespace(namespace))
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(c.Client, c.ResyncPeriod, options...)
+
+	if c.factories == nil {
+		c.factories = map[factoryKey]informers.SharedInformerFactory{}
+	}
+	c.factories[key] = factory
+
+	return factory
+}
+
+// metadataFactory returns the shared metadata-only informer factory for the
+// given scope and selectors, creating it on first use.
+func (c *Client) metadataFactory(namespace string, labelSelector string, fieldSelector string) metadatainformer.SharedInformerFactory {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := factoryKey{
+		namespace:     namespace,
+		labelSelector: labelSelector,
+		fieldSelector: fieldSelector,
+	}
+
+	if factory, ok := c.metadataFactories[key]; ok {
+		return factory
+	}
+
+	factory := metadatainformer.NewFilteredSharedInformerFactory(
+		c.Metadata,
+		c.ResyncPeriod,
+		namespace,
+		c.tweakListOptions(labelSelector, fieldSelector),
+	)
+
+	if c.metadataFactories == nil {
+		c.metadataFactories = map[factoryKey]metadatainformer.SharedInformerFactory{}
+	}
+	c.metadataFactories[key] = factory
+
+	return factory
+}
+
+// dynamicFactory returns the shared dynamic informer factory for the given
+// scope and selectors, creating it on first use.
+func (c *Client) dynamicFactory(namespace string, labelSelector string, fieldSelector string) dynamicinformer.DynamicSharedInformerFactory {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := factoryKey{
+		namespace:     namespace,
+		labelSelector: labelSelector,
+		fieldSelector: fieldSelector,
+	}
+
+	if factory, ok := c.dynamicFactories[key]; ok {
+		return factory
+	}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+		c.Dynamic,
+		c.ResyncPeriod,
+		namespace,
+		c.tweakListOptions(labelSelector, fieldSelector),
+	)
+
+	if c.dynamicFactories == nil {
+		c.dynamicFactories = map[factoryKey]dynamicinformer.DynamicSharedInformerFactory{}
+	}
+	c.dynamicFactories[key] = factory
+
+	return factory
+}
+
+// Shutdown stops the informers once the client context is done and waits
+// for the resources already queued and the queued repairs of local changes to
+// be synced, so files being written and notifications being sent are not cut
+// off. It gives up waiting when ctx is done.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	var shutdowns []func()
+	for _, factory := range c.factories {
+		shutdowns = append(shutdowns, factory.Shutdown)
+	}
+	for _, factory := range c.metadataFactories {
+		shutdowns = append(shutdowns, factory.Shutdown)
+	}
+	for _, factory := range c.dynamicFactories {
+		shutdowns = append(shutdowns, factory.Shutdown)
+	}
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		for _, shutdown := range shutdowns {
+			shutdown()
+		}
+		c.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("handlers did not finish: %w", ctx.Err())
+	}
+}
+
+// watchScopes returns the namespaces the shared informers are scoped to, one
+// per listed namespace so namespace scoped RBAC keeps working. No namespaces
+// and, with ClusterWide, several namespaces are watched cluster wide and
+// filtered by the handlers so the number of watch connections does not grow
+// with the number of namespaces.
+func (c *Client) watchScopes(namespaces []string) []string {
+	if len(namespaces) == 0 || (len(namespaces) > 1 && c.ClusterWide) {
+		return []string{metav1.NamespaceAll}
+	}
+
+	return namespaces
+}
+
+// waitForSync waits until the informer cache has synced, at most
+// CacheSyncTimeout. A cache that never syncs usually means the list or watch
+// requests are forbidden.
+func (c *Client) waitForSync(hasSynced cache.InformerSynced, resource string, namespace string) error {
+	ctx := c.Ctx
+	if c.CacheSyncTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(c.Ctx, c.CacheSyncTimeout)
+		defer cancel()
+	}
+
+	c.mu.Lock()
+	c.syncing++
+	c.mu.Unlock()
+
+	synced := cache.WaitForCacheSync(ctx.Done(), hasSynced)
+
+	c.mu.Lock()
+	c.syncing--
+	if synced {
+		c.synced++
+	}
+	c.mu.Unlock()
+
+	metric := metrics.CacheSynced.WithLabelValues(resource, namespace)
+	if synced {
+		metric.Set(1)
+		metrics.LastSync.SetToCurrentTime()
+		return nil
+	}
+	metric.Set(0)
+
+	if c.Ctx.Err() != nil {
+		return c.Ctx.Err()
+	}
+
+	if namespace == metav1.NamespaceAll {
+		namespace = "all namespaces"
+	}
+
+	return fmt.Errorf("%s cache in %s did not sync within %s, check that the service account may list and watch %s",
+		resource, namespace, c.CacheSyncTimeout, resource)
+}
+
+// watch registers the handler on the shared informer of the given kind and
+// starts it. It returns once the informer cache has synced.
+func (c *Client) watch(kind string, namespace string, h *handler) (cache.SharedIndexInformer, error) {
+	factory := c.factory(namespace, labelSelector(h.label, h.labelValue), h.names.FieldSelector())
+
+	var informer cache.SharedIndexInformer
+	switch kind {
+	case KindConfigMap:
+		informer = factory.Core().V1().ConfigMaps().Informer()
+	case KindSecret:
+		informer = factory.Core().V1().Secrets().Informer()
+	}
+
+	h.addInformer(informer)
+	registration, err := informer.AddEventHandler(h.eventHandler(kind))
+	if err != nil {
+		return nil, err
+	}
+
+	factory.Start(c.Ctx.Done())
+
+	// the handler is synced once the resources in the cache were handed to it
+	return informer, c.waitForSync(registration.HasSynced, kind, namespace)
+}
+
+// watchMetadata is like watch but only caches object metadata. The handler
+// fetches the full object from the API server when it has to write files.
+func (c *Client) watchMetadata(kind string, namespace string, h *handler) (cache.SharedIndexInformer, error) {
+	factory := c.metadataFactory(namespace, labelSelector(h.label, h.labelValue), h.names.FieldSelector())
+
+	var informer cache.SharedIndexInformer
+	switch kind {
+	case KindSecret:
+		informer = factory.ForResource(corev1.SchemeGroupVersion.WithResource("secrets")).Informer()
+	}
+
+	h.addInformer(informer)
+	registration, err := informer.AddEventHandler(h.eventHandler(kind))
+	if err != nil {
+		return nil, err
+	}
+
+	factory.Start(c.Ctx.Done())
+
+	// the handler is synced once the resources in the cache were handed to it
+	return informer, c.waitForSync(registration.HasSynced, kind, namespace)
+}
+
+// watchCustom registers the handler on the shared dynamic informer of the
+// custom resource and starts it. It returns once the informer cache has
+// synced.
+func (c *Client) watchCustom(custom *CustomResource, namespace string, h *handler) (cache.SharedIndexInformer, error) {
+	factory := c.dynamicFactory(namespace, labelSelector(h.label, h.labelValue), h.names.FieldSelector())
+	informer := factory.ForResource(custom.GVR).Informer()
+
+	h.addInformer(informer)
+	registration, err := informer.AddEventHandler(h.eventHandler(""))
+	if err != nil {
+		return nil, err
+	}
+
+	factory.Start(c.Ctx.Done())
+
+	return informer, c.waitForSync(registration.HasSynced, custom.GVR.String(), namespace)
+}
+
+// Target is what a worker syncs: the resources matching the label and names,
+// the folder their files are written to and the notifier called when files
+// changed.
+type Target struct {
+	Label            string
+	LabelValue       string
+	Names            *NameFilter
+	Folder           string
+	FolderAnnotation string
+	Writer           writer.IWriter
+	Notifier         notifier.INotifier
+	// State, when set, skips resources already processed with the same
+	// resourceVersion or content. It defaults to Client.State, workers of
+	// different profiles need stores of their own.
+	State *state.Store
+	// Files records the files written without a State, so the files of
+	// resources synced before the workers started are found there. Workers
+	// without either have a store of their own.
+	Files *state.Store
+}
+
+func (c *Client) newHandler(namespaces *namespaceFilter, target Target) *handler {
+	processed := target.State
+	if processed == nil {
+		processed = c.State
+	}
+	files := processed
+	if files == nil {
+		files = target.Files
+	}
+	if files == nil {
+		files = state.NewStore()
+	}
+	metrics.TrackStore(files)
+
+	h := &handler{
+		client:           c,
+		files:            files,
+		skipProcessed:    processed != nil,
+		namespaces:       namespaces,
+		label:            target.Label,
+		labelValue:       target.LabelValue,
+		names:            target.Names,
+		folder:           target.Folder,
+		folderAnnotation: target.FolderAnnotation,
+		writer:           target.Writer,
+		notifier:         target.Notifier,
+		queue:            newQueue(),
+		pending:          map[string]pendingEvent{},
+		unsynced:         map[string]struct{}{},
+	}
+
+	c.mu.Lock()
+	c.registered = append(c.registered, h)
+	c.mu.Unlock()
+
+	c.handlers.Add(1)
+	go h.work()
+
+	// resources already queued are still synced when shutting down
+	go func() {
+		<-c.Ctx.Done()
+		h.queue.ShutDownWithDrain()
+	}()
+
+	if c.RepairLocalChanges {
+		drift, err := newDriftWatcher(h)
+		if err != nil {
+			l.Error("Failed to watch local changes, they are not repaired:", "error", err)
+		}
+		h.drift = drift
+	}
+
+	return h
+}
+
+func (c *Client) ConfigMapInformerWorker(namespaces []string, target Target) {
+	defer c.Wg.Done()
+
+	l.Debug("Start waiting for ConfigMap changes", "namespaces", namespaces)
+	h := c.newHandler(newNamespaceFilter(namespaces), target)
+	for _, namespace := range c.watchScopes(namespaces) {
+		if _, err := c.watch(KindConfigMap, namespace, h); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+
+	<-c.Ctx.Done()
+}
+
+func (c *Client) SecretInformerWorker(namespaces []string, target Target) {
+	defer c.Wg.Done()
+
+	l.Debug("Start waiting for Secret changes", "namespaces", namespaces)
+	h := c.newHandler(newNamespaceFilter(namespaces), target)
+	for _, namespace := range c.watchScopes(namespaces) {
+		if _, err := c.watch(KindSecret, namespace, h); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+
+	<-c.Ctx.Done()
+}
+
+// SecretMetadataInformerWorker watches Secrets with a metadata-only informer,
+// so secret content is not kept in memory. Matching Secrets are fetched with
+// a GET when they are added or changed.
+func (c *Client) SecretMetadataInformerWorker(namespaces []string, target Target) {
+	defer c.Wg.Done()
+
+	l.Debug("Start waiting for Secret metadata changes", "namespaces", namespaces)
+	h := c.newHandler(newNamespaceFilter(namespaces), target)
+	for _, namespace := range c.watchScopes(namespaces) {
+		if _, err := c.watchMetadata(KindSecret, namespace, h); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+
+	<-c.Ctx.Done()
+}
+
+// CustomResourceInformerWorker watches an arbitrary resource through the
+// dynamic client and writes the file extracted from every matching object.
+func (c *Client) CustomResourceInformerWorker(custom *CustomResource, namespaces []string, target Target) {
+	defer c.Wg.Done()
+
+	l.Debug("Start waiting for custom resource changes", "resource", custom.GVR.String(), "namespaces", namespaces)
+	h := c.newHandler(newNamespaceFilter(namespaces), target)
+	h.custom = custom
+	for _, namespace := range c.watchScopes(namespaces) {
+		if _, err := c.watchCustom(custom, namespace, h); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+
+	<-c.Ctx.Done()
+}
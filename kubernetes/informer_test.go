@@ -0,0 +1,324 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type discardWriter struct{}
+
+func (discardWriter) Write(folder string, fileName string, data string) (bool, error) {
+	return true, nil
+}
+func (discardWriter) Remove(folder string, fileName string) error { return nil }
+func (discardWriter) IsJSON(fileName string) bool                 { return strings.HasSuffix(fileName, ".json") }
+func (discardWriter) Cleanup() error                              { return nil }
+
+type discardNotifier struct{}
+
+func (discardNotifier) Notify() error { return nil }
+
+// startWatching watches ConfigMaps and Secrets in the given namespaces on a
+// fake clientset and returns the number of watch connections opened.
+func startWatching(tb testing.TB, ctx context.Context, namespaces []string, clusterWide bool) (*Client, *int64) {
+	var objects []k8sruntime.Object
+	for _, namespace := range namespaces {
+		objects = append(objects, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboards",
+				Namespace: namespace,
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{"dashboard.json": `{}`},
+		})
+	}
+
+	fakeClientset := fake.NewSimpleClientset(objects...)
+
+	var watches int64
+	fakeClientset.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		atomic.AddInt64(&watches, 1)
+		return false, nil, nil
+	})
+
+	c := &Client{
+		Ctx:         ctx,
+		Client:      fakeClientset,
+		ClusterWide: clusterWide,
+	}
+
+	h := c.newHandler(newNamespaceFilter(namespaces), Target{
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Writer:     discardWriter{},
+		Notifier:   discardNotifier{},
+	})
+
+	for _, namespace := range c.watchScopes(namespaces) {
+		if _, err := c.watch(KindConfigMap, namespace, h); err != nil {
+			tb.Fatalf("Failed to watch: %v", err)
+		}
+		if _, err := c.watch(KindSecret, namespace, h); err != nil {
+			tb.Fatalf("Failed to watch: %v", err)
+		}
+	}
+
+	return c, &watches
+}
+
+// shutdown waits for the informers to stop, the context has to be cancelled
+// first.
+func (c *Client) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, factory := range c.factories {
+		factory.Shutdown()
+	}
+}
+
+func namespaceNames(count int) []string {
+	namespaces := make([]string, count)
+	for i := range namespaces {
+		namespaces[i] = fmt.Sprintf("team-%d", i)
+	}
+	return namespaces
+}
+
+func TestSharedInformers_WatchCountBounded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c, watches := startWatching(t, ctx, namespaceNames(50), true)
+	defer func() {
+		cancel()
+		c.shutdown()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if got := atomic.LoadInt64(watches); got != 2 {
+		t.Errorf("Expected 2 watch connections for 50 namespaces, got %d", got)
+	}
+
+	if len(c.factories) != 1 {
+		t.Errorf("Expected ConfigMaps and Secrets to share 1 factory, got %d", len(c.factories))
+	}
+}
+
+func TestSharedInformers_NamespaceScoped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c, watches := startWatching(t, ctx, namespaceNames(3), false)
+	defer func() {
+		cancel()
+		c.shutdown()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// namespaced Roles in every listed namespace are enough
+	if got := atomic.LoadInt64(watches); got != 6 {
+		t.Errorf("Expected 2 watch connections per namespace, got %d", got)
+	}
+	for _, action := range c.Client.(*fake.Clientset).Actions() {
+		if action.GetNamespace() == metav1.NamespaceAll {
+			t.Errorf("Expected namespace scoped requests only, got %s %s cluster wide", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+
+	if len(c.factories) != 3 {
+		t.Errorf("Expected ConfigMaps and Secrets to share 1 factory per namespace, got %d", len(c.factories))
+	}
+}
+
+func BenchmarkSharedInformers(b *testing.B) {
+	for _, count := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("namespaces=%d", count), func(b *testing.B) {
+			namespaces := namespaceNames(count)
+
+			var totalWatches, totalGoroutines int64
+			for i := 0; i < b.N; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				before := runtime.NumGoroutine()
+
+				c, watches := startWatching(b, ctx, namespaces, true)
+
+				totalGoroutines += int64(runtime.NumGoroutine() - before)
+				totalWatches += atomic.LoadInt64(watches)
+
+				cancel()
+				c.shutdown()
+			}
+
+			b.ReportMetric(float64(totalWatches)/float64(b.N), "watches/op")
+			b.ReportMetric(float64(totalGoroutines)/float64(b.N), "goroutines/op")
+		})
+	}
+}
+
+func TestTweakListOptions_WatchTimeout(t *testing.T) {
+	c := &Client{}
+
+	options := metav1.ListOptions{}
+	c.tweakListOptions("app=grafana", "metadata.name=dashboard")(&options)
+
+	if options.LabelSelector != "app=grafana" || options.FieldSelector != "metadata.name=dashboard" {
+		t.Errorf("Expected selectors to be set, got %q and %q", options.LabelSelector, options.FieldSelector)
+	}
+
+	// without a timeout the reflector keeps its own randomized timeout
+	reflectorTimeout := int64(300)
+	options = metav1.ListOptions{TimeoutSeconds: &reflectorTimeout}
+	c.tweakListOptions("", "")(&options)
+
+	if *options.TimeoutSeconds != 300 {
+		t.Errorf("Expected reflector timeout to be kept, got %d", *options.TimeoutSeconds)
+	}
+
+	c.WatchTimeout = 60 * time.Second
+	c.tweakListOptions("", "")(&options)
+
+	if options.TimeoutSeconds == nil || *options.TimeoutSeconds != 60 {
+		t.Errorf("Expected server side timeout of 60 seconds, got %v", options.TimeoutSeconds)
+	}
+}
+
+// recordingWriter keeps the files written in memory.
+type recordingWriter struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func (w *recordingWriter) Write(folder string, fileName string, data string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.files[filepath.Join(folder, fileName)] = data
+	return true, nil
+}
+
+func (w *recordingWriter) Remove(folder string, fileName string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.files, filepath.Join(folder, fileName))
+	return nil
+}
+
+func (w *recordingWriter) IsJSON(fileName string) bool { return strings.HasSuffix(fileName, ".json") }
+func (w *recordingWriter) Cleanup() error              { return nil }
+
+func (w *recordingWriter) has(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.files[path]
+	return ok
+}
+
+func TestProfiles_Selectors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "dashboards", Namespace: "monitoring", Labels: map[string]string{"grafana_dashboard": "1"}},
+			Data:       map[string]string{"dashboard.json": `{}`},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "datasources", Namespace: "monitoring", Labels: map[string]string{"grafana_datasource": "1"}},
+			Data:       map[string]string{"datasource.json": `{}`},
+		},
+	)
+
+	var watches int64
+	fakeClientset.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		atomic.AddInt64(&watches, 1)
+		return false, nil, nil
+	})
+
+	c := &Client{
+		Ctx:    ctx,
+		Client: fakeClientset,
+	}
+	defer func() {
+		cancel()
+		c.shutdown()
+	}()
+
+	// the profiles with the same selector share an informer
+	w := &recordingWriter{files: map[string]string{}}
+	for _, target := range []Target{
+		{Label: "grafana_dashboard", Folder: "/dashboards", Writer: w, Notifier: discardNotifier{}},
+		{Label: "grafana_datasource", Folder: "/datasources", Writer: w, Notifier: discardNotifier{}},
+		{Label: "grafana_dashboard", Folder: "/backup", Writer: w, Notifier: discardNotifier{}},
+	} {
+		h := c.newHandler(newNamespaceFilter([]string{"monitoring"}), target)
+		if _, err := c.watch(KindConfigMap, "monitoring", h); err != nil {
+			t.Fatalf("Failed to watch: %v", err)
+		}
+	}
+
+	waitFor(t, func() bool {
+		return w.has("/dashboards/dashboard.json") && w.has("/datasources/datasource.json") && w.has("/backup/dashboard.json")
+	})
+	if w.has("/dashboards/datasource.json") || w.has("/datasources/dashboard.json") {
+		t.Errorf("Expected each profile to write only its own files, got %v", w.files)
+	}
+
+	if got := atomic.LoadInt64(&watches); got != 2 {
+		t.Errorf("Expected 1 watch connection per selector, got %d", got)
+	}
+
+	// only labelled resources are listed and cached
+	for _, action := range fakeClientset.Actions() {
+		var selector string
+		switch action := action.(type) {
+		case k8stesting.ListAction:
+			selector = action.GetListRestrictions().Labels.String()
+		case k8stesting.WatchAction:
+			selector = action.GetWatchRestrictions().Labels.String()
+		default:
+			continue
+		}
+		if selector != "grafana_dashboard" && selector != "grafana_datasource" {
+			t.Errorf("Expected %s %s with the label selector of a profile, got %q", action.GetVerb(), action.GetResource().Resource, selector)
+		}
+	}
+
+	// a ConfigMap whose label was removed is gone from the profile
+	_, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Update(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "dashboards", Namespace: "monitoring"},
+		Data:       map[string]string{"dashboard.json": `{}`},
+	}, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to update ConfigMap: %v", err)
+	}
+
+	waitFor(t, func() bool { return !w.has("/dashboards/dashboard.json") })
+	if !w.has("/datasources/datasource.json") {
+		t.Error("Expected the files of the other profile to be kept")
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
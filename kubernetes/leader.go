@@ -0,0 +1,118 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the pod in a cluster.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// LeaderElection configures the Lease replicas compete for, only the holder
+// of the Lease syncs resources.
+type LeaderElection struct {
+	Name      string
+	Namespace string
+	// Identity tells the replicas apart, usually the pod name.
+	Identity string
+	// LeaseDuration is how long followers wait before they take over a Lease
+	// that was not renewed, the leader gives up when it could not renew the
+	// Lease for two thirds of it.
+	LeaseDuration time.Duration
+}
+
+// PodNamespace returns the namespace the sidecar runs in, "default" outside
+// of a cluster.
+func PodNamespace() string {
+	data, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return metav1.NamespaceDefault
+	}
+
+	if namespace := strings.TrimSpace(string(data)); namespace != "" {
+		return namespace
+	}
+
+	return metav1.NamespaceDefault
+}
+
+// RunAsLeader blocks until the Lease is acquired and then calls run with a
+// context that is cancelled when the leadership is lost. The Lease is released
+// when run returns or the client context is done, so a follower takes over
+// without waiting for the Lease to expire. Losing the Lease while run is
+// still running is an error.
+func (c *Client) RunAsLeader(election LeaderElection, run func(ctx context.Context) error) error {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      election.Name,
+			Namespace: election.Namespace,
+		},
+		Client: c.Client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: election.Identity,
+		},
+	}
+
+	// run may hand its context on to the client, keep the one to stop at
+	parent := c.Ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var (
+		leading atomic.Bool
+		runErr  error
+	)
+	done := make(chan struct{})
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            election.Name,
+		LeaseDuration:   election.LeaseDuration,
+		RenewDeadline:   election.LeaseDuration * 2 / 3,
+		RetryPeriod:     election.LeaseDuration * 2 / 15,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				leading.Store(true)
+				defer close(done)
+				l.Info("Acquired leadership:", "lease", election.Namespace+"/"+election.Name, "identity", election.Identity)
+
+				runErr = run(ctx)
+				if runErr == nil && ctx.Err() != nil && parent.Err() == nil {
+					runErr = fmt.Errorf("lost leadership of lease %s/%s", election.Namespace, election.Name)
+				}
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				l.Info("Stopped leading:", "lease", election.Namespace+"/"+election.Name, "identity", election.Identity)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != election.Identity {
+					l.Info("Following leader:", "lease", election.Namespace+"/"+election.Name, "leader", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up leader election: %w", err)
+	}
+
+	l.Info("Waiting for leadership:", "lease", election.Namespace+"/"+election.Name, "identity", election.Identity)
+	elector.Run(ctx)
+
+	// Run returns before the context is done only after the Lease was
+	// acquired and lost, it does not wait for run to return
+	if !leading.Load() && ctx.Err() != nil {
+		return nil
+	}
+	<-done
+
+	return runErr
+}
@@ -0,0 +1,126 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testElection(identity string) LeaderElection {
+	return LeaderElection{
+		Name:          "k8s-gsidecar",
+		Namespace:     "default",
+		Identity:      identity,
+		LeaseDuration: 1500 * time.Millisecond,
+	}
+}
+
+func TestRunAsLeader_Failover(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset()
+
+	leaders := make(chan string, 2)
+	runAsLeader := func(ctx context.Context, identity string) chan error {
+		c := &Client{Ctx: ctx, Client: fakeClientset}
+		result := make(chan error, 1)
+		go func() {
+			result <- c.RunAsLeader(testElection(identity), func(ctx context.Context) error {
+				leaders <- identity
+				<-ctx.Done()
+				return nil
+			})
+		}()
+		return result
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	resultA := runAsLeader(ctxA, "a")
+
+	select {
+	case leader := <-leaders:
+		if leader != "a" {
+			t.Fatalf("Expected a to lead, got %s", leader)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a to lead")
+	}
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	resultB := runAsLeader(ctxB, "b")
+
+	select {
+	case leader := <-leaders:
+		t.Fatalf("Expected only a to lead, %s leads as well", leader)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// a releases the Lease on shutdown, b takes over before it expires
+	cancelA()
+	if err := <-resultA; err != nil {
+		t.Errorf("Expected a to stop without error, got %v", err)
+	}
+
+	select {
+	case leader := <-leaders:
+		if leader != "b" {
+			t.Fatalf("Expected b to lead, got %s", leader)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for b to take over")
+	}
+
+	cancelB()
+	if err := <-resultB; err != nil {
+		t.Errorf("Expected b to stop without error, got %v", err)
+	}
+}
+
+func TestRunAsLeader_LostLease(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset()
+	var unavailable atomic.Bool
+	fakeClientset.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		if !unavailable.Load() {
+			return false, nil, nil
+		}
+		return true, nil, errors.New("API server unavailable")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &Client{Ctx: ctx, Client: fakeClientset}
+	leading := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- c.RunAsLeader(testElection("a"), func(ctx context.Context) error {
+			close(leading)
+			<-ctx.Done()
+			return nil
+		})
+	}()
+
+	select {
+	case <-leading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for leadership")
+	}
+
+	// the Lease can no longer be renewed
+	unavailable.Store(true)
+
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "lost leadership") {
+			t.Errorf("Expected losing the lease to fail, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the leadership to be lost")
+	}
+}
@@ -0,0 +1,239 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespaceFilter is the set of namespaces whose resources are synced. It is
+// checked by the handlers of the cluster wide informers and can change while
+// they run.
+type namespaceFilter struct {
+	mu    sync.RWMutex
+	all   bool
+	names map[string]struct{}
+}
+
+// newNamespaceFilter returns a filter for a static list of namespaces, an
+// empty list selects all namespaces.
+func newNamespaceFilter(namespaces []string) *namespaceFilter {
+	f := &namespaceFilter{
+		all:   len(namespaces) == 0,
+		names: map[string]struct{}{},
+	}
+
+	for _, namespace := range namespaces {
+		f.names[namespace] = struct{}{}
+	}
+
+	return f
+}
+
+func (f *namespaceFilter) Match(namespace string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.all {
+		return true
+	}
+
+	_, ok := f.names[namespace]
+	return ok
+}
+
+// add selects the namespace and reports whether it was not selected before.
+func (f *namespaceFilter) add(namespace string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.names[namespace]; ok {
+		return false
+	}
+
+	f.names[namespace] = struct{}{}
+	return true
+}
+
+// remove deselects the namespace and reports whether it was selected before.
+func (f *namespaceFilter) remove(namespace string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.names[namespace]; !ok {
+		return false
+	}
+
+	delete(f.names, namespace)
+	return true
+}
+
+// ListNamespaces returns the names of all namespaces matching the label
+// selector.
+func (c *Client) ListNamespaces(selector string) ([]string, error) {
+	namespaces, err := c.Client.CoreV1().Namespaces().List(c.Ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(namespaces.Items))
+	for _, namespace := range namespaces.Items {
+		names = append(names, namespace.Name)
+	}
+
+	return names, nil
+}
+
+// WatchedResources lists the resources synced by NamespaceSelectorWorker.
+type WatchedResources struct {
+	ConfigMaps         bool
+	Secrets            bool
+	SecretMetadataOnly bool
+	Custom             *CustomResource
+}
+
+// NamespaceSelectorWorker watches Namespace objects matching the selector and
+// syncs the watched resources of every namespace in the selection. The
+// resources are watched cluster wide once; when a namespace enters the
+// selection its cached resources are written, when it is deleted or its
+// labels stop matching the files it contributed are removed.
+func (c *Client) NamespaceSelectorWorker(
+	selector string,
+	watched WatchedResources,
+	target Target,
+) {
+	defer c.Wg.Done()
+
+	namespaceSelector, err := labels.Parse(selector)
+	if err != nil {
+		c.fail(fmt.Errorf("invalid namespace selector %q: %w", selector, err))
+		return
+	}
+
+	h := c.newHandler(&namespaceFilter{names: map[string]struct{}{}}, target)
+	h.custom = watched.Custom
+
+	type kindInformer struct {
+		kind     string
+		informer cache.SharedIndexInformer
+	}
+
+	var resourceInformers []kindInformer
+	watch := func(kind string, informer cache.SharedIndexInformer, err error) bool {
+		if err != nil {
+			c.fail(err)
+			return false
+		}
+		resourceInformers = append(resourceInformers, kindInformer{kind, informer})
+		return true
+	}
+
+	if watched.ConfigMaps {
+		informer, err := c.watch(KindConfigMap, metav1.NamespaceAll, h)
+		if !watch(KindConfigMap, informer, err) {
+			return
+		}
+	}
+	if watched.Secrets {
+		watchSecrets := c.watch
+		if watched.SecretMetadataOnly {
+			watchSecrets = c.watchMetadata
+		}
+		informer, err := watchSecrets(KindSecret, metav1.NamespaceAll, h)
+		if !watch(KindSecret, informer, err) {
+			return
+		}
+	}
+	if watched.Custom != nil {
+		informer, err := c.watchCustom(watched.Custom, metav1.NamespaceAll, h)
+		if !watch("", informer, err) {
+			return
+		}
+	}
+
+	// cached resources of a namespace, used to replay a namespace entering or
+	// leaving the selection
+	cached := func(namespace string) []*resource {
+		var resources []*resource
+		for _, ki := range resourceInformers {
+			items, err := ki.informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+			if err != nil {
+				l.Error("Failed to list cached resources:", "namespace", namespace, "error", err)
+				continue
+			}
+			for _, item := range items {
+				if res, ok := h.toResource(ki.kind, item); ok && h.selects(res) {
+					resources = append(resources, res)
+				}
+			}
+		}
+		return resources
+	}
+
+	// the resources of a namespace entering or leaving the selection are
+	// queued, the workers write or remove their files
+	start := func(namespace string) {
+		if !h.namespaces.add(namespace) {
+			return
+		}
+
+		l.Info("Namespace entered selection:", "namespace", namespace)
+		for _, res := range cached(namespace) {
+			h.enqueue(res, pendingEvent{})
+		}
+	}
+
+	stop := func(namespace string) {
+		if !h.namespaces.remove(namespace) {
+			return
+		}
+
+		l.Info("Namespace left selection:", "namespace", namespace)
+		for _, res := range cached(namespace) {
+			h.enqueue(res, pendingEvent{})
+		}
+	}
+
+	factory := c.factory(metav1.NamespaceAll, selector, "")
+	nsInformer := factory.Core().V1().Namespaces().Informer()
+
+	nsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ns := obj.(*corev1.Namespace)
+			if namespaceSelector.Matches(labels.Set(ns.Labels)) {
+				start(ns.Name)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			ns := newObj.(*corev1.Namespace)
+			if namespaceSelector.Matches(labels.Set(ns.Labels)) {
+				start(ns.Name)
+			} else {
+				stop(ns.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			switch ns := obj.(type) {
+			case *corev1.Namespace:
+				stop(ns.Name)
+			case cache.DeletedFinalStateUnknown:
+				_, name, _ := cache.SplitMetaNamespaceKey(ns.Key)
+				stop(name)
+			}
+		},
+	})
+
+	factory.Start(c.Ctx.Done())
+	if err := c.waitForSync(nsInformer.HasSynced, "Namespace", metav1.NamespaceAll); err != nil {
+		c.fail(err)
+		return
+	}
+
+	<-c.Ctx.Done()
+}
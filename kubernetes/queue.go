@@ -0,0 +1,157 @@
+package kubernetes
+
+import (
+	"k8s-gsidecar/state"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// queueRetryBaseDelay is how long a resource that failed to sync waits
+	// before it is retried, the delay doubles with every failure up to
+	// queueRetryMaxDelay.
+	queueRetryBaseDelay = 500 * time.Millisecond
+	queueRetryMaxDelay  = 5 * time.Minute
+)
+
+// pendingEvent is what the informer events tell about a queued resource
+// beyond its key.
+type pendingEvent struct {
+	// resync is set when the resource was replayed by a resync, its files
+	// are written again even when it was already processed
+	resync bool
+	// deleted is the last state of a deleted resource, its files are removed
+	// based on it when nothing was recorded for them
+	deleted *resource
+}
+
+func newQueue() workqueue.TypedRateLimitingInterface[string] {
+	return workqueue.NewTypedRateLimitingQueueWithConfig(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[string](queueRetryBaseDelay, queueRetryMaxDelay),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "resources"},
+	)
+}
+
+// enqueue queues the resource for the workers. A resync or deletion is kept
+// until the resource is reconciled, events for a resource already queued are
+// merged into one reconcile.
+func (h *handler) enqueue(res *resource, event pendingEvent) {
+	key := res.key()
+
+	h.mu.Lock()
+	pending := h.pending[key]
+	pending.resync = pending.resync || event.resync
+	pending.deleted = event.deleted
+	h.pending[key] = pending
+	h.unsynced[key] = struct{}{}
+	h.mu.Unlock()
+
+	h.queue.Add(key)
+}
+
+// synced reports whether every queued resource was reconciled without a
+// failure.
+func (h *handler) synced() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.unsynced) == 0
+}
+
+// take returns and forgets what is pending for the key.
+func (h *handler) take(key string) pendingEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pending := h.pending[key]
+	delete(h.pending, key)
+
+	return pending
+}
+
+// work reconciles queued resources until the queue is shut down. Resources
+// that failed to sync are queued again with an exponential backoff.
+func (h *handler) work() {
+	defer h.client.handlers.Done()
+
+	for {
+		key, shutdown := h.queue.Get()
+		if shutdown {
+			return
+		}
+
+		pending := h.take(key)
+		if err := h.reconcile(key, pending); err != nil {
+			l.Error("Failed to sync, retrying:", "resource", key, "retries", h.queue.NumRequeues(key), "error", err)
+
+			// a retry of a resync still writes the files
+			h.mu.Lock()
+			if _, ok := h.pending[key]; !ok {
+				h.pending[key] = pendingEvent{resync: pending.resync}
+			}
+			h.mu.Unlock()
+
+			h.queue.AddRateLimited(key)
+		} else {
+			// a resource queued again meanwhile is still to be synced
+			h.mu.Lock()
+			if _, ok := h.pending[key]; !ok {
+				delete(h.unsynced, key)
+			}
+			h.mu.Unlock()
+
+			h.queue.Forget(key)
+		}
+
+		h.queue.Done(key)
+	}
+}
+
+// reconcile brings the files of the resource in line with the informer
+// cache: the files of a matching resource are written, those of a resource
+// that is gone or no longer matches are removed. The notifier is called when files changed.
+func (h *handler) reconcile(key string, pending pendingEvent) error {
+	res, exists := h.cached(key)
+	if !exists {
+		deleted := pending.deleted
+		if deleted == nil {
+			kind, namespace, name := state.SplitKey(key)
+			deleted = &resource{kind: kind, meta: &metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		}
+		if h.removeFiles(deleted) > 0 {
+			h.notify(deleted)
+		}
+		return nil
+	}
+
+	// a resource whose labels stopped matching or whose namespace left the
+	// selection: only the files recorded for it are removed, nothing was
+	// written for other resources
+	if !h.matches(res) {
+		if h.remove(res, h.files.Delete(key)) > 0 {
+			h.notify(res)
+		}
+		return nil
+	}
+
+	if !pending.resync && h.processed(res) {
+		return nil
+	}
+
+	res, err := h.load(res)
+	if err != nil {
+		return err
+	}
+	if res == nil || (!pending.resync && h.unchanged(res)) {
+		return nil
+	}
+
+	written, err := h.writeFiles(res)
+	if written > 0 {
+		h.notify(res)
+	}
+
+	return err
+}
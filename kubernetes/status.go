@@ -0,0 +1,149 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// StatusAnnotationPrefix starts the annotations the sync status of a resource
+// is reported with, it is followed by the identity of the reporting pod.
+const StatusAnnotationPrefix = "k8s-sidecar/synced-by."
+
+// SyncStatus is the value of a status annotation.
+type SyncStatus struct {
+	// ResourceVersion is the version of the resource that was synced.
+	ResourceVersion string `json:"resourceVersion"`
+	Time            string `json:"time"`
+	// Result is ReasonSynced or ReasonWriteFailed.
+	Result string `json:"result"`
+}
+
+// StatusAnnotation returns the annotation a pod reports the sync status with.
+func StatusAnnotation(identity string) string {
+	return StatusAnnotationPrefix + identity
+}
+
+// statusFieldManager is the server-side apply field manager of a pod. Every
+// pod owns nothing but its own annotation, so applying it never removes the
+// annotations of other pods.
+func statusFieldManager(identity string) string {
+	return "k8s-gsidecar-" + identity
+}
+
+// ReportSyncStatus writes the sync result into the status annotation of the
+// resource when SyncStatusIdentity is set. The annotation is only applied
+// again when files were written or the result changed, so the update event
+// caused by applying it does not lead to another apply.
+func (c *Client) ReportSyncStatus(kind string, obj metav1.Object, custom *CustomResource, written int, result string) {
+	if c.SyncStatusIdentity == "" {
+		return
+	}
+
+	annotation := StatusAnnotation(c.SyncStatusIdentity)
+	if written == 0 {
+		var current SyncStatus
+		if value, ok := obj.GetAnnotations()[annotation]; ok && json.Unmarshal([]byte(value), &current) == nil && current.Result == result {
+			return
+		}
+	}
+
+	value, err := json.Marshal(SyncStatus{
+		ResourceVersion: obj.GetResourceVersion(),
+		Time:            time.Now().UTC().Format(time.RFC3339),
+		Result:          result,
+	})
+	if err != nil {
+		l.Error("Failed to encode sync status:", "error", err)
+		return
+	}
+
+	if err := c.applyAnnotation(kind, obj, custom, annotation, string(value)); err != nil {
+		l.Error("Failed to report sync status:", "kind", kind, "namespace", obj.GetNamespace(), "name", obj.GetName(), "error", err)
+		return
+	}
+	l.Debug("Reported sync status:", "kind", kind, "namespace", obj.GetNamespace(), "name", obj.GetName(), "result", result)
+}
+
+// applyAnnotation sets the annotation with server-side apply. The
+// resourceVersion is applied as a precondition, it keeps a resource deleted
+// in the meantime from being created again.
+func (c *Client) applyAnnotation(kind string, obj metav1.Object, custom *CustomResource, annotation string, value string) error {
+	options := metav1.ApplyOptions{
+		FieldManager: statusFieldManager(c.SyncStatusIdentity),
+		Force:        true,
+	}
+	annotations := map[string]string{annotation: value}
+
+	switch kind {
+	case KindConfigMap:
+		configMap := corev1ac.ConfigMap(obj.GetName(), obj.GetNamespace()).
+			WithResourceVersion(obj.GetResourceVersion()).
+			WithAnnotations(annotations)
+		_, err := c.Client.CoreV1().ConfigMaps(obj.GetNamespace()).Apply(c.Ctx, configMap, options)
+		return err
+	case KindSecret:
+		secret := corev1ac.Secret(obj.GetName(), obj.GetNamespace()).
+			WithResourceVersion(obj.GetResourceVersion()).
+			WithAnnotations(annotations)
+		_, err := c.Client.CoreV1().Secrets(obj.GetNamespace()).Apply(c.Ctx, secret, options)
+		return err
+	}
+
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok || custom == nil || c.Dynamic == nil {
+		return fmt.Errorf("cannot apply annotations to %s", kind)
+	}
+
+	patch := &unstructured.Unstructured{}
+	patch.SetAPIVersion(object.GetAPIVersion())
+	patch.SetKind(object.GetKind())
+	patch.SetName(object.GetName())
+	patch.SetNamespace(object.GetNamespace())
+	patch.SetResourceVersion(object.GetResourceVersion())
+	patch.SetAnnotations(annotations)
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Dynamic.Resource(custom.GVR).Namespace(object.GetNamespace()).Patch(c.Ctx, object.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: options.FieldManager,
+		Force:        &options.Force,
+	})
+	return err
+}
+
+// statusOnly reports whether an update changed nothing but status
+// annotations, e.g. the one just applied by ReportSyncStatus. The content of
+// metadata-only resources is unknown, they are never status only.
+func statusOnly(old *resource, res *resource) bool {
+	if old.metadataOnly || res.metadataOnly {
+		return false
+	}
+
+	if !maps.Equal(old.data, res.data) || !maps.Equal(old.meta.GetLabels(), res.meta.GetLabels()) {
+		return false
+	}
+
+	return maps.Equal(withoutStatus(old.meta.GetAnnotations()), withoutStatus(res.meta.GetAnnotations()))
+}
+
+func withoutStatus(annotations map[string]string) map[string]string {
+	filtered := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, StatusAnnotationPrefix) {
+			filtered[key] = value
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,102 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestReportSyncStatus(t *testing.T) {
+	ctx := context.Background()
+	fakeClientset := fake.NewClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "dashboard",
+			Namespace:       "monitoring",
+			ResourceVersion: "1",
+			Annotations:     map[string]string{"owner": "team-a"},
+		},
+	})
+
+	applies := 0
+	fakeClientset.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		applies++
+		return false, nil, nil
+	})
+
+	get := func() *corev1.ConfigMap {
+		configMap, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Get(ctx, "dashboard", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Failed to get ConfigMap: %v", err)
+		}
+		return configMap
+	}
+
+	a := &Client{Ctx: ctx, Client: fakeClientset, SyncStatusIdentity: "sidecar-a"}
+	b := &Client{Ctx: ctx, Client: fakeClientset, SyncStatusIdentity: "sidecar-b"}
+
+	a.ReportSyncStatus(KindConfigMap, get(), nil, 1, ReasonSynced)
+	b.ReportSyncStatus(KindConfigMap, get(), nil, 0, ReasonWriteFailed)
+
+	annotations := get().Annotations
+	if annotations["owner"] != "team-a" {
+		t.Errorf("Expected other annotations to be kept, got %v", annotations)
+	}
+
+	var status SyncStatus
+	if err := json.Unmarshal([]byte(annotations[StatusAnnotation("sidecar-a")]), &status); err != nil {
+		t.Fatalf("Failed to parse status of sidecar-a: %v", err)
+	}
+	if status.Result != ReasonSynced || status.ResourceVersion == "" || status.Time == "" {
+		t.Errorf("Expected a Synced status with version and time, got %+v", status)
+	}
+	if err := json.Unmarshal([]byte(annotations[StatusAnnotation("sidecar-b")]), &status); err != nil {
+		t.Fatalf("Failed to parse status of sidecar-b: %v", err)
+	}
+	if status.Result != ReasonWriteFailed {
+		t.Errorf("Expected a WriteFailed status of sidecar-b, got %+v", status)
+	}
+
+	// an unchanged result without written files is not applied again
+	a.ReportSyncStatus(KindConfigMap, get(), nil, 0, ReasonSynced)
+	if applies != 2 {
+		t.Errorf("Expected 2 applies, got %d", applies)
+	}
+
+	// without an identity nothing is reported
+	(&Client{Ctx: ctx, Client: fakeClientset}).ReportSyncStatus(KindConfigMap, get(), nil, 1, ReasonSynced)
+	if applies != 2 {
+		t.Errorf("Expected no apply without an identity, got %d", applies)
+	}
+}
+
+func TestStatusOnly(t *testing.T) {
+	resourceWith := func(data string, annotations map[string]string) *resource {
+		return &resource{
+			kind: KindConfigMap,
+			meta: &metav1.ObjectMeta{Annotations: annotations},
+			data: map[string]string{"dashboard.json": data},
+		}
+	}
+
+	old := resourceWith(`{}`, map[string]string{"owner": "team-a"})
+	if !statusOnly(old, resourceWith(`{}`, map[string]string{"owner": "team-a", StatusAnnotation("sidecar-a"): "{}"})) {
+		t.Error("Expected a new status annotation to be status only")
+	}
+	if statusOnly(old, resourceWith(`{"title": "new"}`, map[string]string{"owner": "team-a"})) {
+		t.Error("Expected changed data not to be status only")
+	}
+	if statusOnly(old, resourceWith(`{}`, map[string]string{"owner": "team-b"})) {
+		t.Error("Expected a changed annotation not to be status only")
+	}
+
+	metadataOnly := &resource{kind: KindSecret, meta: &metav1.ObjectMeta{}, metadataOnly: true}
+	if statusOnly(metadataOnly, metadataOnly) {
+		t.Error("Expected metadata-only resources never to be status only")
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"k8s-gsidecar/logger"
 	"log/slog"
 	"os"
@@ -11,25 +13,67 @@ import (
 
 var l *slog.Logger = logger.GetLogger()
 
+// Exit codes of the sidecar.
+const (
+	exitSuccess = 0
+	exitFailure = 1
+	// exitTimeout means the list method gave up waiting for its files
+	exitTimeout = 2
+)
+
 func main() {
+	configFile := flag.String("config", os.Getenv(CONFIG_FILE), "YAML or JSON configuration file, environment variables override its settings")
+	flag.Parse()
+
+	os.Exit(run(func(ctx context.Context) (*SideCar, error) {
+		return NewFromConfig(ctx, *configFile)
+	}))
+}
 
+// run sets up the sidecar with newSideCar and runs it until it is done or
+// SIGINT or SIGTERM is received. A signal cancels the context, Run then
+// returns once the handlers finished writing files and notifying. It returns
+// the exit code.
+func run(newSideCar func(ctx context.Context) (*SideCar, error)) int {
 	l.Info("Starting SideCar")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
 
 	go func() {
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-		l.Info("Received signal to exit, cancelling context")
-		cancel()
+		select {
+		case sig := <-sigChan:
+			l.Info("Received signal to exit, cancelling context", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
 
-	sideCar := New(ctx)
+	sideCar, err := newSideCar(ctx)
+	if err != nil {
+		l.Error("Failed to set up SideCar", "error", err)
+		return exitFailure
+	}
+
 	l.Info("Running SideCar")
-	sideCar.Run()
+	if err := sideCar.Run(); err != nil {
+		l.Error("SideCar failed", "error", err)
+		return exitCode(err)
+	}
 
 	l.Info("SideCar exited")
+	return exitSuccess
+}
+
+// exitCode returns the exit code for the error the sidecar failed with.
+func exitCode(err error) int {
+	if errors.Is(err, errInitTimeout) {
+		return exitTimeout
+	}
+
+	return exitFailure
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"k8s-gsidecar/kubernetes"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+// blockingWriter blocks writing one file until it is released.
+type blockingWriter struct {
+	*MockWriter
+	mu       sync.Mutex
+	fileName string
+	writing  chan struct{}
+	release  chan struct{}
+	cleanups atomic.Int32
+}
+
+func (w *blockingWriter) Write(folder string, fileName string, data string) (bool, error) {
+	if fileName == w.fileName {
+		close(w.writing)
+		<-w.release
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.MockWriter.Write(folder, fileName, data)
+}
+
+func (w *blockingWriter) Cleanup() error {
+	w.cleanups.Add(1)
+	return nil
+}
+
+func TestRun_Shutdown(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	mockWriter := &blockingWriter{
+		MockWriter: NewMockWriter(),
+		fileName:   "slow.json",
+		writing:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	mockNotifier := NewMockNotifier()
+
+	sideCars := make(chan *SideCar, 1)
+	newSideCar := func(ctx context.Context) (*SideCar, error) {
+		sideCar := &SideCar{
+			ctx: ctx,
+			client: &kubernetes.Client{
+				Ctx:    ctx,
+				Client: fakeClientset,
+			},
+			writer:              mockWriter,
+			notifier:            mockNotifier,
+			Method:              METHOD_WATCH,
+			Namespaces:          []string{"monitoring"},
+			Label:               "grafana_dashboard",
+			LabelValue:          "1",
+			Resource:            []string{RESOURCE_CONFIGMAP},
+			SkipRBACCheck:       true,
+			ShutdownGracePeriod: 5 * time.Second,
+		}
+		sideCars <- sideCar
+		return sideCar, nil
+	}
+
+	exitCodes := make(chan int, 1)
+	go func() {
+		exitCodes <- run(newSideCar)
+	}()
+
+	sideCar := <-sideCars
+	deadline := time.Now().Add(5 * time.Second)
+	for !sideCar.client.CachesSynced() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the informer cache to sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Create(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "slow",
+			Namespace: "monitoring",
+			Labels:    map[string]string{"grafana_dashboard": "1"},
+		},
+		Data: map[string]string{
+			"slow.json": `{"title": "Slow"}`,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+
+	select {
+	case <-mockWriter.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the write to start")
+	}
+
+	// SIGTERM while the handler is writing
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("Failed to send SIGTERM: %v", err)
+	}
+
+	select {
+	case code := <-exitCodes:
+		t.Fatalf("Expected run to wait for the write in flight, exited with %d", code)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(mockWriter.release)
+
+	select {
+	case code := <-exitCodes:
+		if code != exitSuccess {
+			t.Errorf("Expected exit code %d, got %d", exitSuccess, code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for run to return")
+	}
+
+	if _, ok := mockWriter.WrittenFiles["slow.json"]; !ok {
+		t.Error("Expected the write in flight to finish")
+	}
+	if mockNotifier.NotifyCount == 0 {
+		t.Error("Expected the notification of the write in flight to be sent")
+	}
+}
+
+func TestRun_SetupFailed(t *testing.T) {
+	code := run(func(ctx context.Context) (*SideCar, error) {
+		return nil, errors.New("no cluster")
+	})
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+}
+
+func TestWaitForChanges_ShutdownGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "slow",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"slow.json": `{"title": "Slow"}`,
+			},
+		},
+	)
+
+	mockWriter := &blockingWriter{
+		MockWriter: NewMockWriter(),
+		fileName:   "slow.json",
+		writing:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	// the write never finishes within the grace period
+	defer close(mockWriter.release)
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:              mockWriter,
+		notifier:            NewMockNotifier(),
+		Namespaces:          []string{"monitoring"},
+		Label:               "grafana_dashboard",
+		LabelValue:          "1",
+		Resource:            []string{RESOURCE_CONFIGMAP},
+		ShutdownGracePeriod: 200 * time.Millisecond,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sideCar.WaitForChanges()
+	}()
+
+	select {
+	case <-mockWriter.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the write to start")
+	}
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("Expected WaitForChanges to return without error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected WaitForChanges to give up after the grace period")
+	}
+
+	if mockWriter.cleanups.Load() != 1 {
+		t.Errorf("Expected the temporary files to be cleaned up once, got %d", mockWriter.cleanups.Load())
+	}
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"k8s-gsidecar/notifier"
+	"k8s-gsidecar/writer"
+	"time"
+)
+
+// Writer counts the files written and removed by the writer it wraps.
+type Writer struct {
+	writer.IWriter
+}
+
+func NewWriter(w writer.IWriter) *Writer {
+	return &Writer{IWriter: w}
+}
+
+func (w *Writer) Write(folder string, fileName string, data string) (bool, error) {
+	changed, err := w.IWriter.Write(folder, fileName, data)
+	if err != nil {
+		WriteErrors.Inc()
+	} else if changed {
+		FilesWritten.Inc()
+	}
+
+	return changed, err
+}
+
+func (w *Writer) Remove(folder string, fileName string) error {
+	err := w.IWriter.Remove(folder, fileName)
+	if err != nil {
+		WriteErrors.Inc()
+	} else {
+		FilesRemoved.Inc()
+	}
+
+	return err
+}
+
+// Notifier counts and times the notifications of the notifier it wraps.
+type Notifier struct {
+	notifier.INotifier
+}
+
+func NewNotifier(n notifier.INotifier) *Notifier {
+	return &Notifier{INotifier: n}
+}
+
+func (n *Notifier) Notify() error {
+	Notifications.Inc()
+	start := time.Now()
+
+	err := n.INotifier.Notify()
+	NotificationDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		NotificationFailures.Inc()
+	}
+
+	return err
+}
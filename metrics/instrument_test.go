@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"errors"
+	"k8s-gsidecar/state"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type stubWriter struct {
+	changed bool
+	err     error
+}
+
+func (w *stubWriter) Write(folder string, fileName string, data string) (bool, error) {
+	return w.changed, w.err
+}
+func (w *stubWriter) Remove(folder string, fileName string) error { return w.err }
+func (w *stubWriter) IsJSON(fileName string) bool                 { return true }
+func (w *stubWriter) Cleanup() error                              { return nil }
+
+type stubNotifier struct {
+	err error
+}
+
+func (n *stubNotifier) Notify() error { return n.err }
+
+func TestWriter(t *testing.T) {
+	written := testutil.ToFloat64(FilesWritten)
+	removed := testutil.ToFloat64(FilesRemoved)
+	writeErrors := testutil.ToFloat64(WriteErrors)
+
+	stub := &stubWriter{changed: true}
+	w := NewWriter(stub)
+	w.Write("folder", "a.json", "{}")
+	w.Remove("folder", "a.json")
+
+	// unchanged files are not counted as written
+	stub.changed = false
+	w.Write("folder", "a.json", "{}")
+
+	stub.err = errors.New("read-only file system")
+	w.Write("folder", "a.json", "{}")
+	w.Remove("folder", "a.json")
+
+	if got := testutil.ToFloat64(FilesWritten) - written; got != 1 {
+		t.Errorf("Expected 1 file written, got %v", got)
+	}
+	if got := testutil.ToFloat64(FilesRemoved) - removed; got != 1 {
+		t.Errorf("Expected 1 file removed, got %v", got)
+	}
+	if got := testutil.ToFloat64(WriteErrors) - writeErrors; got != 2 {
+		t.Errorf("Expected 2 write errors, got %v", got)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	notifications := testutil.ToFloat64(Notifications)
+	failures := testutil.ToFloat64(NotificationFailures)
+
+	stub := &stubNotifier{}
+	n := NewNotifier(stub)
+	n.Notify()
+	stub.err = errors.New("connection refused")
+	if err := n.Notify(); err == nil {
+		t.Error("Expected the notifier error to be returned")
+	}
+
+	if got := testutil.ToFloat64(Notifications) - notifications; got != 2 {
+		t.Errorf("Expected 2 notifications, got %v", got)
+	}
+	if got := testutil.ToFloat64(NotificationFailures) - failures; got != 1 {
+		t.Errorf("Expected 1 failed notification, got %v", got)
+	}
+}
+
+func TestManagedFiles(t *testing.T) {
+	store := state.NewStore()
+	store.Set("ConfigMap/monitoring/a", state.Entry{Files: []state.File{{Folder: "/tmp", Name: "a.json"}, {Folder: "/tmp", Name: "b.json"}}})
+
+	before := managedFiles()
+	TrackStore(store)
+	TrackStore(store)
+
+	if got := managedFiles() - before; got != 2 {
+		t.Errorf("Expected 2 managed files, got %v", got)
+	}
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"k8s-gsidecar/state"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gsidecar"
+
+var (
+	// Events counts the informer events received, by kind and type (add,
+	// update or delete).
+	Events = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Informer events received by resource kind and event type.",
+	}, []string{"kind", "type"})
+
+	FilesWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_written_total",
+		Help:      "Files written with changed content.",
+	})
+
+	FilesRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_removed_total",
+		Help:      "Files removed.",
+	})
+
+	WriteErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "write_errors_total",
+		Help:      "Files that failed to be written or removed.",
+	})
+
+	Notifications = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Notifications attempted.",
+	})
+
+	NotificationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notification_failures_total",
+		Help:      "Notifications that failed.",
+	})
+
+	NotificationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "notification_duration_seconds",
+		Help:      "Duration of notifications.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// CacheSynced is 1 when the informer cache of a resource in a namespace
+	// synced, an empty namespace is all namespaces.
+	CacheSynced = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_synced",
+		Help:      "Whether the informer cache of a resource in a namespace synced.",
+	}, []string{"resource", "namespace"})
+
+	LastSync = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_sync_timestamp_seconds",
+		Help:      "Unix time of the last successful sync.",
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "managed_files",
+		Help:      "Files currently managed by the sidecar.",
+	}, managedFiles)
+)
+
+var (
+	mu     sync.Mutex
+	stores = map[*state.Store]struct{}{}
+)
+
+// TrackStore counts the files recorded in the store as managed files. A
+// store is only counted once however often it is tracked.
+func TrackStore(store *state.Store) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	stores[store] = struct{}{}
+}
+
+func managedFiles() float64 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	files := 0
+	for store := range stores {
+		files += store.Files()
+	}
+
+	return float64(files)
+}
+
+// Handler serves the metrics in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"k8s-gsidecar/metrics"
+	"k8s-gsidecar/notifier"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// newProfile returns a sync profile sharing the client and writer of the
+// sidecar. Settings the profile leaves out are those of the sidecar.
+func (s *SideCar) newProfile(config ProfileConfig) *SideCar {
+	profile := &SideCar{
+		ctx:                    s.ctx,
+		client:                 s.client,
+		writer:                 s.writer,
+		notifier:               s.notifier,
+		name:                   config.Name,
+		Method:                 s.Method,
+		ClusterWideWatch:       s.ClusterWideWatch,
+		Namespaces:             s.Namespaces,
+		NamespaceSelector:      s.NamespaceSelector,
+		Label:                  s.Label,
+		LabelValue:             s.LabelValue,
+		UniqueFilenames:        s.UniqueFilenames,
+		Folder:                 s.Folder,
+		FolderAnnotation:       s.FolderAnnotation,
+		Resource:               s.Resource,
+		ResourceName:           s.ResourceName,
+		ReqPayload:             s.ReqPayload,
+		ReqURL:                 s.ReqURL,
+		ReqMethod:              s.ReqMethod,
+		ReqBasicAuthUsername:   s.ReqBasicAuthUsername,
+		ReqBasicAuthPassword:   s.ReqBasicAuthPassword,
+		ReqSkipInit:            s.ReqSkipInit,
+		IgnoreAlreadyProcessed: s.IgnoreAlreadyProcessed,
+		SyncStatusAnnotation:   s.SyncStatusAnnotation,
+		SecretMetadataOnly:     s.SecretMetadataOnly,
+		CustomResource:         s.CustomResource,
+		CustomResourceFileName: s.CustomResourceFileName,
+		CustomResourceContent:  s.CustomResourceContent,
+	}
+
+	// namespaces and namespace selector replace each other
+	if len(config.Namespaces) > 0 || config.NamespaceSelector != "" {
+		profile.Namespaces = []string{}
+		if len(config.Namespaces) > 0 && config.Namespaces[0] != "ALL" {
+			profile.Namespaces = config.Namespaces
+		}
+		profile.NamespaceSelector = config.NamespaceSelector
+	}
+	if config.Label != "" {
+		profile.Label = config.Label
+		profile.LabelValue = config.LabelValue
+	}
+	if config.Resource != "" {
+		profile.Resource = resourceList(config.Resource)
+	}
+	if len(config.ResourceNames) > 0 {
+		profile.ResourceName = strings.Join(config.ResourceNames, ",")
+	}
+	if config.Folder != "" {
+		profile.Folder = config.Folder
+	}
+	if config.FolderAnnotation != "" {
+		profile.FolderAnnotation = config.FolderAnnotation
+	}
+	if config.SecretMetadataOnly != nil {
+		profile.SecretMetadataOnly = *config.SecretMetadataOnly
+	}
+	if custom := config.CustomResource; custom != nil {
+		if custom.Resource != "" {
+			profile.CustomResource = custom.Resource
+		}
+		if custom.FileName != "" {
+			profile.CustomResourceFileName = custom.FileName
+		}
+		if custom.Content != "" {
+			profile.CustomResourceContent = custom.Content
+		}
+	}
+
+	if n := config.Notifier; n != nil {
+		profile.ReqURL = n.URL
+		profile.ReqMethod = n.Method
+		profile.ReqPayload = n.Payload
+		profile.ReqBasicAuthUsername = n.Username
+		profile.ReqBasicAuthPassword = n.Password
+		if n.SkipInit != nil {
+			profile.ReqSkipInit = strconv.FormatBool(*n.SkipInit)
+		}
+		// a profile notifier without a URL turns notifications off
+		profile.notifier = nil
+		if n.URL != "" {
+			profile.notifier = metrics.NewNotifier(notifier.NewHTTPNotifier(
+				n.URL,
+				n.Method,
+				&notifier.BasicAuth{Username: n.Username, Password: n.Password},
+				n.Payload,
+			))
+		}
+	}
+
+	// the same resource may be synced by several profiles, each remembers
+	// what it processed on its own
+	if s.ignoreProcessed() {
+		profile.StateFile = profileStateFile(s.StateFile, config.Name)
+		profile.state = newStateStore(profile.StateFile)
+		metrics.TrackStore(profile.state)
+	}
+
+	l.Info("Sync profile:", "profile", profile.name, "label", profile.Label, "resources", profile.Resource, "folder", profile.Folder)
+
+	return profile
+}
+
+// profileStateFile returns the state file of a profile, the state file with
+// the profile name before its extension.
+func profileStateFile(stateFile string, name string) string {
+	if stateFile == "" {
+		return ""
+	}
+
+	ext := filepath.Ext(stateFile)
+	return strings.TrimSuffix(stateFile, ext) + "." + name + ext
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewFromConfig_Profiles(t *testing.T) {
+	unsetenv(t, METHOD, NAMESPACE, LABEL, LABEL_VALUE, RESOURCE, FOLDER, REQ_URL, IGNORE_ALREADY_PROCESSED, STATE_FILE)
+	setKubeconfig(t)
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte(`
+method: watch
+namespaces: [monitoring]
+label: grafana_dashboard
+resource: both
+folder: /tmp/dashboards
+ignoreAlreadyProcessed: true
+stateFile: `+stateFile+`
+notifier:
+  url: http://localhost:3000/api/admin/provisioning/dashboards/reload
+profiles:
+  - name: dashboards
+  - name: datasources
+    namespaces: [ALL]
+    label: grafana_datasource
+    labelValue: "1"
+    resource: secret
+    folder: /tmp/datasources
+    notifier:
+      url: http://localhost:3000/api/admin/provisioning/datasources/reload
+`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	sideCar, err := NewFromConfig(context.Background(), configFile)
+	if err != nil {
+		t.Fatalf("NewFromConfig failed: %v", err)
+	}
+
+	if len(sideCar.profiles) != 2 {
+		t.Fatalf("Expected 2 profiles, got %d", len(sideCar.profiles))
+	}
+	if sideCar.profiles[0].client != sideCar.client || sideCar.profiles[1].client != sideCar.client {
+		t.Error("Expected the profiles to share the client")
+	}
+
+	dashboards, datasources := sideCar.profiles[0], sideCar.profiles[1]
+
+	// a profile without settings of its own syncs like the sidecar
+	if dashboards.Label != "grafana_dashboard" || dashboards.Folder != "/tmp/dashboards" ||
+		!reflect.DeepEqual(dashboards.Namespaces, []string{"monitoring"}) ||
+		!reflect.DeepEqual(dashboards.Resource, []string{RESOURCE_CONFIGMAP, RESOURCE_SECRET}) {
+		t.Errorf("Expected the dashboards profile to inherit the settings, got %+v", dashboards)
+	}
+	if dashboards.notifier != sideCar.notifier {
+		t.Error("Expected the dashboards profile to share the notifier")
+	}
+
+	if datasources.Label != "grafana_datasource" || datasources.LabelValue != "1" || datasources.Folder != "/tmp/datasources" {
+		t.Errorf("Expected the datasources profile settings, got %+v", datasources)
+	}
+	if len(datasources.Namespaces) != 0 {
+		t.Errorf("Expected the datasources profile to watch all namespaces, got %v", datasources.Namespaces)
+	}
+	if !reflect.DeepEqual(datasources.Resource, []string{RESOURCE_SECRET}) {
+		t.Errorf("Expected the datasources profile to sync secrets, got %v", datasources.Resource)
+	}
+	if datasources.notifier == sideCar.notifier || datasources.ReqURL != "http://localhost:3000/api/admin/provisioning/datasources/reload" {
+		t.Error("Expected the datasources profile to have its own notifier")
+	}
+
+	// the profiles remember what they processed on their own
+	if dashboards.state == nil || datasources.state == nil || dashboards.state == datasources.state {
+		t.Error("Expected a state store per profile")
+	}
+	if sideCar.client.State != nil {
+		t.Error("Expected no top level state store with profiles")
+	}
+	if expected := filepath.Join(filepath.Dir(stateFile), "state.datasources.json"); datasources.StateFile != expected {
+		t.Errorf("Expected state file %s, got %s", expected, datasources.StateFile)
+	}
+}
+
+func TestProfileStateFile(t *testing.T) {
+	tests := []struct {
+		stateFile string
+		expected  string
+	}{
+		{"/var/lib/sidecar/state.json", "/var/lib/sidecar/state.dashboards.json"},
+		{"/var/lib/sidecar/state", "/var/lib/sidecar/state.dashboards"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if actual := profileStateFile(tt.stateFile, "dashboards"); actual != tt.expected {
+			t.Errorf("Expected %q for %q, got %q", tt.expected, tt.stateFile, actual)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"k8s-gsidecar/metrics"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serverShutdownTimeout bounds how long open requests may take once the
+// sidecar stops.
+const serverShutdownTimeout = 5 * time.Second
+
+// serve starts the HTTP servers of the metrics and health endpoints, sharing
+// one server when they use the same port. It fails when a port cannot be
+// bound and stops serving when the context is done.
+func (s *SideCar) serve() error {
+	muxes := map[string]*http.ServeMux{}
+	mux := func(port string) *http.ServeMux {
+		if muxes[port] == nil {
+			muxes[port] = http.NewServeMux()
+		}
+		return muxes[port]
+	}
+
+	if s.MetricsPort != "" {
+		mux(s.MetricsPort).Handle("/metrics", metrics.Handler())
+	}
+	if s.HealthPort != "" {
+		mux(s.HealthPort).HandleFunc("/healthz", s.healthz)
+		mux(s.HealthPort).HandleFunc("/readyz", s.readyz)
+		mux(s.HealthPort).HandleFunc("/startupz", s.startupz)
+	}
+
+	for port, mux := range muxes {
+		listener, err := net.Listen("tcp", net.JoinHostPort("", port))
+		if err != nil {
+			return fmt.Errorf("failed to listen on port %s: %w", port, err)
+		}
+
+		server := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			l.Info("Serving HTTP", "address", listener.Addr().String())
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				l.Error("HTTP server failed", "error", err)
+			}
+		}()
+
+		ctx := s.ctx
+		go func() {
+			<-ctx.Done()
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+	}
+
+	return nil
+}
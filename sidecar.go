@@ -2,36 +2,87 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"k8s-gsidecar/kubernetes"
+	"k8s-gsidecar/metrics"
 	"k8s-gsidecar/notifier"
+	"k8s-gsidecar/state"
 	"k8s-gsidecar/writer"
-	"log"
-	"log/slog"
+	"maps"
 	"os"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
-	METHOD                   = "METHOD"
-	NAMESPACE                = "NAMESPACE"
-	UNIQUE_FILENAMES         = "UNIQUE_FILENAMES"
-	FOLDER                   = "FOLDER"
-	FOLDER_ANNOTATION        = "FOLDER_ANNOTATION"
-	LABEL                    = "LABEL"
-	LABEL_VALUE              = "LABEL_VALUE"
-	RESOURCE                 = "RESOURCE"
-	RESOURCE_NAME            = "RESOURCE_NAME"
-	REQ_PAYLOAD              = "REQ_PAYLOAD"
-	REQ_URL                  = "REQ_URL"
-	REQ_METHOD               = "REQ_METHOD"
-	REQ_SKIP_INIT            = "REQ_SKIP_INIT"
-	SCRIPT                   = "SCRIPT"
-	ENABLE_5XX               = "ENABLE_5XX"
-	IGNORE_ALREADY_PROCESSED = "IGNORE_ALREADY_PROCESSED"
-	REQ_USERNAME             = "REQ_USERNAME"
-	REQ_PASSWORD             = "REQ_PASSWORD"
+	METHOD                         = "METHOD"
+	NAMESPACE                      = "NAMESPACE"
+	NAMESPACE_SELECTOR             = "NAMESPACE_SELECTOR"
+	UNIQUE_FILENAMES               = "UNIQUE_FILENAMES"
+	FOLDER                         = "FOLDER"
+	FOLDER_ANNOTATION              = "FOLDER_ANNOTATION"
+	LABEL                          = "LABEL"
+	LABEL_VALUE                    = "LABEL_VALUE"
+	RESOURCE                       = "RESOURCE"
+	RESOURCE_NAME                  = "RESOURCE_NAME"
+	REQ_PAYLOAD                    = "REQ_PAYLOAD"
+	REQ_URL                        = "REQ_URL"
+	REQ_METHOD                     = "REQ_METHOD"
+	REQ_SKIP_INIT                  = "REQ_SKIP_INIT"
+	SCRIPT                         = "SCRIPT"
+	ENABLE_5XX                     = "ENABLE_5XX"
+	IGNORE_ALREADY_PROCESSED       = "IGNORE_ALREADY_PROCESSED"
+	REQ_USERNAME                   = "REQ_USERNAME"
+	REQ_PASSWORD                   = "REQ_PASSWORD"
+	SECRET_METADATA_ONLY           = "SECRET_METADATA_ONLY"
+	CUSTOM_RESOURCE                = "CUSTOM_RESOURCE"
+	CUSTOM_RESOURCE_FILENAME       = "CUSTOM_RESOURCE_FILENAME"
+	CUSTOM_RESOURCE_CONTENT        = "CUSTOM_RESOURCE_CONTENT"
+	STATE_FILE                     = "STATE_FILE"
+	SLEEP_TIME                     = "SLEEP_TIME"
+	RESYNC_PERIOD                  = "RESYNC_PERIOD"
+	WATCH_SERVER_TIMEOUT           = "WATCH_SERVER_TIMEOUT"
+	CLUSTER_WIDE_WATCH             = "CLUSTER_WIDE_WATCH"
+	REPAIR_LOCAL_CHANGES           = "REPAIR_LOCAL_CHANGES"
+	CACHE_SYNC_TIMEOUT             = "CACHE_SYNC_TIMEOUT"
+	SKIP_RBAC_CHECK                = "SKIP_RBAC_CHECK"
+	KUBECONFIG                     = "KUBECONFIG"
+	KUBE_CONTEXT                   = "KUBE_CONTEXT"
+	KUBE_QPS                       = "KUBE_QPS"
+	KUBE_BURST                     = "KUBE_BURST"
+	KUBE_USER_AGENT                = "KUBE_USER_AGENT"
+	IMPERSONATE_USER               = "IMPERSONATE_USER"
+	IMPERSONATE_GROUPS             = "IMPERSONATE_GROUPS"
+	IMPERSONATE_SERVICE_ACCOUNT    = "IMPERSONATE_SERVICE_ACCOUNT"
+	LEADER_ELECTION                = "LEADER_ELECTION"
+	LEADER_ELECTION_NAME           = "LEADER_ELECTION_NAME"
+	LEADER_ELECTION_NAMESPACE      = "LEADER_ELECTION_NAMESPACE"
+	LEADER_ELECTION_IDENTITY       = "LEADER_ELECTION_IDENTITY"
+	LEADER_ELECTION_LEASE_DURATION = "LEADER_ELECTION_LEASE_DURATION"
+	DISABLE_EVENTS                 = "DISABLE_EVENTS"
+	SYNC_STATUS_ANNOTATION         = "SYNC_STATUS_ANNOTATION"
+	METRICS_PORT                   = "METRICS_PORT"
+	HEALTH_PORT                    = "HEALTH_PORT"
+	READY_FILE                     = "READY_FILE"
+	INIT_STRICT                    = "INIT_STRICT"
+	INIT_MIN_FILES                 = "INIT_MIN_FILES"
+	INIT_RESOURCES                 = "INIT_RESOURCES"
+	INIT_TIMEOUT                   = "INIT_TIMEOUT"
+	INIT_RETRY_INTERVAL            = "INIT_RETRY_INTERVAL"
+	SHUTDOWN_GRACE_PERIOD          = "SHUTDOWN_GRACE_PERIOD"
+	CONFIG_FILE                    = "CONFIG_FILE"
 )
 
 const (
@@ -44,74 +95,162 @@ const (
 	RESOURCE_ALL       string = "both"
 	RESOURCE_CONFIGMAP string = "configmap"
 	RESOURCE_SECRET    string = "secret"
+	RESOURCE_CUSTOM    string = "custom"
 )
 
 const (
-	DEFAULT_FOLDER_ANNOTATION = "k8s-sidecar-target-directory"
+	DEFAULT_FOLDER_ANNOTATION              = "k8s-sidecar-target-directory"
+	DEFAULT_CUSTOM_RESOURCE_FILENAME       = "{.metadata.name}.json"
+	DEFAULT_SLEEP_TIME                     = 60 * time.Second
+	DEFAULT_CACHE_SYNC_TIMEOUT             = 60 * time.Second
+	DEFAULT_LEADER_ELECTION_NAME           = "k8s-gsidecar"
+	DEFAULT_LEADER_ELECTION_LEASE_DURATION = 15 * time.Second
+	DEFAULT_INIT_RETRY_INTERVAL            = 5 * time.Second
+	DEFAULT_SHUTDOWN_GRACE_PERIOD          = 20 * time.Second
 )
 
+// errInitTimeout is returned by the list method when the files it waits for
+// are not in place within InitTimeout.
+var errInitTimeout = errors.New("timed out waiting for files")
+
 type SideCar struct {
 	ctx      context.Context
 	client   *kubernetes.Client
 	writer   writer.IWriter
 	notifier notifier.INotifier
+	// files remembers the files written by the list based methods, it is
+	// the store of processed resources when IGNORE_ALREADY_PROCESSED is set
+	files *state.Store
+	// profiles are synced instead of the sidecar itself, each with its own
+	// selection, folder and notifier. They share the client of the sidecar,
+	// state is the store of processed resources of a profile.
+	profiles []*SideCar
+	name     string
+	state    *state.Store
+	// ready is set once the initial sync wrote all files, leading while
+	// this replica holds the Lease
+	ready     atomic.Bool
+	readyOnce sync.Once
+	leading   atomic.Bool
 
-	Method                 string
-	Namespaces             []string
-	Label                  string
-	LabelValue             string
-	UniqueFilenames        string
-	Folder                 string
-	FolderAnnotation       string
-	Resource               []string
-	ResourceName           string
-	ReqPayload             string
-	ReqURL                 string
-	ReqMethod              string
-	ReqBasicAuthUsername   string
-	ReqBasicAuthPassword   string
-	ReqSkipInit            string
-	Script                 string
-	Enable5XX              string
-	IgnoreAlreadyProcessed string
-}
-
-func New(ctx context.Context) *SideCar {
-	client, err := kubernetes.NewClient(ctx)
-	if err != nil {
-		l.Error("Failed to create Kubernetes client", "error", err)
-	}
-
-	resouce := os.Getenv(RESOURCE)
-	reqURL := os.Getenv(REQ_URL)
-	reqMethod := os.Getenv(REQ_METHOD)
-	reqPayload := os.Getenv(REQ_PAYLOAD)
-	reqUsername := os.Getenv(REQ_USERNAME)
-	reqPassword := os.Getenv(REQ_PASSWORD)
-	folderAnnotation := os.Getenv(FOLDER_ANNOTATION)
-	resources := []string{}
-	switch resouce {
-	case RESOURCE_ALL:
-		resources = []string{RESOURCE_CONFIGMAP, RESOURCE_SECRET}
-	case RESOURCE_CONFIGMAP:
-		resources = []string{RESOURCE_CONFIGMAP}
-	case RESOURCE_SECRET:
-		resources = []string{RESOURCE_SECRET}
+	Method                      string
+	Namespaces                  []string
+	NamespaceSelector           string
+	Label                       string
+	LabelValue                  string
+	UniqueFilenames             string
+	Folder                      string
+	FolderAnnotation            string
+	Resource                    []string
+	ResourceName                string
+	ReqPayload                  string
+	ReqURL                      string
+	ReqMethod                   string
+	ReqBasicAuthUsername        string
+	ReqBasicAuthPassword        string
+	ReqSkipInit                 string
+	Script                      string
+	Enable5XX                   string
+	IgnoreAlreadyProcessed      string
+	StateFile                   string
+	SleepTime                   time.Duration
+	ResyncPeriod                time.Duration
+	WatchServerTimeout          time.Duration
+	ClusterWideWatch            bool
+	RepairLocalChanges          bool
+	CacheSyncTimeout            time.Duration
+	SkipRBACCheck               bool
+	LeaderElection              bool
+	LeaderElectionName          string
+	LeaderElectionNamespace     string
+	LeaderElectionIdentity      string
+	LeaderElectionLeaseDuration time.Duration
+	DisableEvents               bool
+	SyncStatusAnnotation        bool
+	MetricsPort                 string
+	HealthPort                  string
+	ReadyFile                   string
+	InitStrict                  bool
+	InitMinFiles                int
+	InitResources               []string
+	InitTimeout                 time.Duration
+	InitRetryInterval           time.Duration
+	ShutdownGracePeriod         time.Duration
+	SecretMetadataOnly          bool
+	CustomResource              string
+	CustomResourceFileName      string
+	CustomResourceContent       string
+}
+
+// New sets up the sidecar from the environment variables and the
+// configuration file named by CONFIG_FILE.
+func New(ctx context.Context) (*SideCar, error) {
+	return NewFromConfig(ctx, os.Getenv(CONFIG_FILE))
+}
+
+// NewFromConfig sets up the sidecar from the configuration file, environment
+// variables that are set override its settings. Without a file only the
+// environment variables are used.
+func NewFromConfig(ctx context.Context, configFile string) (*SideCar, error) {
+	config := &Config{}
+	if configFile != "" {
+		var err error
+		config, err = LoadConfig(configFile)
+		if err != nil {
+			return nil, err
+		}
+		l.Info("Loaded config file", "path", configFile, "profiles", len(config.Profiles))
+	}
+
+	// the environment variables are checked like the file
+	errs := config.applyEnv()
+	errs = append(errs, config.validate()...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid settings: %w", errors.Join(errs...))
+	}
+	e := config.env()
+
+	var impersonateGroups []string
+	if groups := e.get(IMPERSONATE_GROUPS); groups != "" {
+		impersonateGroups = strings.Split(groups, ",")
 	}
-	basicAuth := &notifier.BasicAuth{
-		Username: reqUsername,
-		Password: reqPassword,
+
+	client, err := kubernetes.NewClient(ctx, kubernetes.Options{
+		Kubeconfig:                e.get(KUBECONFIG),
+		Context:                   e.get(KUBE_CONTEXT),
+		QPS:                       float32(e.float(KUBE_QPS, 0)),
+		Burst:                     e.int(KUBE_BURST, 0),
+		UserAgent:                 e.get(KUBE_USER_AGENT),
+		ImpersonateUser:           e.get(IMPERSONATE_USER),
+		ImpersonateGroups:         impersonateGroups,
+		ImpersonateServiceAccount: e.get(IMPERSONATE_SERVICE_ACCOUNT),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kubernetes: %w", err)
 	}
+
+	resouce := e.get(RESOURCE)
+	reqURL := e.get(REQ_URL)
+	reqMethod := e.get(REQ_METHOD)
+	reqPayload := e.get(REQ_PAYLOAD)
+	reqUsername := e.get(REQ_USERNAME)
+	reqPassword := e.get(REQ_PASSWORD)
+	folderAnnotation := e.get(FOLDER_ANNOTATION)
+	resources := resourceList(resouce)
 	fw := writer.NewFileWriter()
 
-	notifier := notifier.NewHTTPNotifier(
-		reqURL,
-		reqMethod,
-		basicAuth,
-		reqPayload,
-	)
+	// without REQ_URL there is nothing to notify
+	var sideCarNotifier notifier.INotifier
+	if reqURL != "" {
+		sideCarNotifier = metrics.NewNotifier(notifier.NewHTTPNotifier(
+			reqURL,
+			reqMethod,
+			&notifier.BasicAuth{Username: reqUsername, Password: reqPassword},
+			reqPayload,
+		))
+	}
 
-	namesapces_env := os.Getenv(NAMESPACE)
+	namesapces_env := e.get(NAMESPACE)
 	var namespaces []string
 	if namesapces_env == "" || namesapces_env == "ALL" {
 		namespaces = []string{}
@@ -119,152 +258,1237 @@ func New(ctx context.Context) *SideCar {
 		namespaces = strings.Split(namesapces_env, ",")
 	}
 
+	namespaceSelector := e.get(NAMESPACE_SELECTOR)
+	if namespaceSelector != "" && len(namespaces) > 0 {
+		l.Warn("NAMESPACE_SELECTOR is set, ignoring NAMESPACE", "namespaces", namespaces)
+		namespaces = []string{}
+	}
+
 	if folderAnnotation == "" {
 		folderAnnotation = DEFAULT_FOLDER_ANNOTATION
 	}
 
-	return &SideCar{
-		ctx:                    ctx,
-		client:                 client,
-		writer:                 fw,
-		notifier:               notifier,
-		Namespaces:             namespaces,
-		Method:                 strings.ToLower(os.Getenv(METHOD)),
-		UniqueFilenames:        os.Getenv(UNIQUE_FILENAMES),
-		Folder:                 os.Getenv(FOLDER),
-		FolderAnnotation:       folderAnnotation,
-		Label:                  os.Getenv(LABEL),
-		LabelValue:             os.Getenv(LABEL_VALUE),
-		Resource:               resources,
-		ResourceName:           os.Getenv(RESOURCE_NAME),
-		ReqPayload:             reqPayload,
-		ReqURL:                 reqURL,
-		ReqMethod:              reqMethod,
-		ReqBasicAuthUsername:   reqUsername,
-		ReqBasicAuthPassword:   reqPassword,
-		ReqSkipInit:            os.Getenv(REQ_SKIP_INIT),
-		Script:                 os.Getenv(SCRIPT),
-		Enable5XX:              os.Getenv(ENABLE_5XX),
-		IgnoreAlreadyProcessed: os.Getenv(IGNORE_ALREADY_PROCESSED),
-	}
-}
-
-func (s *SideCar) Run() {
+	ignoreAlreadyProcessed := e.get(IGNORE_ALREADY_PROCESSED)
+	stateFile := e.get(STATE_FILE)
+	// profiles remember what they processed in stores of their own
+	if e.bool(IGNORE_ALREADY_PROCESSED) && len(config.Profiles) == 0 {
+		client.State = newStateStore(stateFile)
+		metrics.TrackStore(client.State)
+	}
+
+	resyncPeriod := e.seconds(RESYNC_PERIOD, 0)
+	watchServerTimeout := e.seconds(WATCH_SERVER_TIMEOUT, 0)
+	clusterWideWatch := e.bool(CLUSTER_WIDE_WATCH)
+	repairLocalChanges := e.bool(REPAIR_LOCAL_CHANGES)
+	cacheSyncTimeout := e.seconds(CACHE_SYNC_TIMEOUT, DEFAULT_CACHE_SYNC_TIMEOUT)
+	client.CacheSyncTimeout = cacheSyncTimeout
+	client.ResyncPeriod = resyncPeriod
+	client.WatchTimeout = watchServerTimeout
+	client.ClusterWide = clusterWideWatch
+	client.RepairLocalChanges = repairLocalChanges
+
+	customResourceFileName := e.get(CUSTOM_RESOURCE_FILENAME)
+	if customResourceFileName == "" {
+		customResourceFileName = DEFAULT_CUSTOM_RESOURCE_FILENAME
+	}
+
+	leaderElectionName := e.get(LEADER_ELECTION_NAME)
+	if leaderElectionName == "" {
+		leaderElectionName = DEFAULT_LEADER_ELECTION_NAME
+	}
+	leaderElectionNamespace := e.get(LEADER_ELECTION_NAMESPACE)
+	if leaderElectionNamespace == "" {
+		leaderElectionNamespace = kubernetes.PodNamespace()
+	}
+	// the hostname of a pod is its name
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the hostname: %w", err)
+	}
+	leaderElectionIdentity := e.get(LEADER_ELECTION_IDENTITY)
+	if leaderElectionIdentity == "" {
+		leaderElectionIdentity = hostname
+	}
+
+	var initResources []string
+	if resources := e.get(INIT_RESOURCES); resources != "" {
+		initResources = strings.Split(resources, ",")
+	}
+
+	disableEvents := e.bool(DISABLE_EVENTS)
+	if !disableEvents {
+		client.Events = client.NewEventRecorder("k8s-gsidecar", hostname)
+	}
+
+	syncStatusAnnotation := e.bool(SYNC_STATUS_ANNOTATION)
+	if syncStatusAnnotation {
+		if errs := validation.IsQualifiedName(kubernetes.StatusAnnotation(hostname)); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid sync status annotation for pod %s: %s", hostname, strings.Join(errs, ", "))
+		}
+		client.SyncStatusIdentity = hostname
+	}
+
+	sideCar := &SideCar{
+		ctx:                         ctx,
+		client:                      client,
+		writer:                      metrics.NewWriter(fw),
+		notifier:                    sideCarNotifier,
+		Namespaces:                  namespaces,
+		NamespaceSelector:           namespaceSelector,
+		Method:                      strings.ToLower(e.get(METHOD)),
+		UniqueFilenames:             e.get(UNIQUE_FILENAMES),
+		Folder:                      e.get(FOLDER),
+		FolderAnnotation:            folderAnnotation,
+		Label:                       e.get(LABEL),
+		LabelValue:                  e.get(LABEL_VALUE),
+		Resource:                    resources,
+		ResourceName:                e.get(RESOURCE_NAME),
+		ReqPayload:                  reqPayload,
+		ReqURL:                      reqURL,
+		ReqMethod:                   reqMethod,
+		ReqBasicAuthUsername:        reqUsername,
+		ReqBasicAuthPassword:        reqPassword,
+		ReqSkipInit:                 e.get(REQ_SKIP_INIT),
+		Script:                      e.get(SCRIPT),
+		Enable5XX:                   e.get(ENABLE_5XX),
+		IgnoreAlreadyProcessed:      ignoreAlreadyProcessed,
+		StateFile:                   stateFile,
+		SleepTime:                   e.seconds(SLEEP_TIME, DEFAULT_SLEEP_TIME),
+		ResyncPeriod:                resyncPeriod,
+		WatchServerTimeout:          watchServerTimeout,
+		ClusterWideWatch:            clusterWideWatch,
+		RepairLocalChanges:          repairLocalChanges,
+		CacheSyncTimeout:            cacheSyncTimeout,
+		SkipRBACCheck:               e.bool(SKIP_RBAC_CHECK),
+		LeaderElection:              e.bool(LEADER_ELECTION),
+		LeaderElectionName:          leaderElectionName,
+		LeaderElectionNamespace:     leaderElectionNamespace,
+		LeaderElectionIdentity:      leaderElectionIdentity,
+		LeaderElectionLeaseDuration: e.seconds(LEADER_ELECTION_LEASE_DURATION, DEFAULT_LEADER_ELECTION_LEASE_DURATION),
+		DisableEvents:               disableEvents,
+		SyncStatusAnnotation:        syncStatusAnnotation,
+		MetricsPort:                 e.get(METRICS_PORT),
+		HealthPort:                  e.get(HEALTH_PORT),
+		ReadyFile:                   e.get(READY_FILE),
+		InitStrict:                  e.bool(INIT_STRICT),
+		InitMinFiles:                e.int(INIT_MIN_FILES, 0),
+		InitResources:               initResources,
+		InitTimeout:                 e.seconds(INIT_TIMEOUT, 0),
+		InitRetryInterval:           e.seconds(INIT_RETRY_INTERVAL, DEFAULT_INIT_RETRY_INTERVAL),
+		ShutdownGracePeriod:         e.seconds(SHUTDOWN_GRACE_PERIOD, DEFAULT_SHUTDOWN_GRACE_PERIOD),
+		SecretMetadataOnly:          e.bool(SECRET_METADATA_ONLY),
+		CustomResource:              e.get(CUSTOM_RESOURCE),
+		CustomResourceFileName:      customResourceFileName,
+		CustomResourceContent:       e.get(CUSTOM_RESOURCE_CONTENT),
+	}
+
+	// the profiles share the client, informers of profiles watching the same
+	// namespaces with the same selectors are shared as well
+	for _, profile := range config.Profiles {
+		sideCar.profiles = append(sideCar.profiles, sideCar.newProfile(profile))
+	}
+
+	return sideCar, nil
+}
+
+// resourceList returns the resources synced for a RESOURCE value.
+func resourceList(resource string) []string {
+	switch resource {
+	case RESOURCE_ALL:
+		return []string{RESOURCE_CONFIGMAP, RESOURCE_SECRET}
+	case RESOURCE_CONFIGMAP, RESOURCE_SECRET, RESOURCE_CUSTOM:
+		return []string{resource}
+	}
+
+	return []string{}
+}
+
+// env looks up settings in the environment variables, falling back to the
+// values of the configuration file. A variable that is set wins, also when
+// it is empty.
+type env map[string]string
+
+func (e env) get(key string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return e[key]
+}
+
+// bool reads a boolean setting, anything that does not parse as true is
+// false.
+func (e env) bool(key string) bool {
+	value, err := strconv.ParseBool(e.get(key))
+	if err != nil {
+		return false
+	}
+
+	return value
+}
+
+// seconds reads a duration in seconds, falling back to def when it is unset or
+// invalid.
+func (e env) seconds(key string, def time.Duration) time.Duration {
+	value := e.get(key)
+	if value == "" {
+		return def
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		l.Warn("Invalid duration, using default:", "key", key, "value", value, "default", def)
+		return def
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// float reads a non-negative number, falling back to def when it is unset or
+// invalid.
+func (e env) float(key string, def float64) float64 {
+	value := e.get(key)
+	if value == "" {
+		return def
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		l.Warn("Invalid number, using default:", "key", key, "value", value, "default", def)
+		return def
+	}
+
+	return number
+}
+
+// int reads a non-negative integer, falling back to def when it is unset or
+// invalid.
+func (e env) int(key string, def int) int {
+	value := e.get(key)
+	if value == "" {
+		return def
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		l.Warn("Invalid number, using default:", "key", key, "value", value, "default", def)
+		return def
+	}
+
+	return number
+}
+
+// newStateStore returns the store remembering processed resources, persisted
+// to the state file when one is configured.
+func newStateStore(stateFile string) *state.Store {
+	if stateFile == "" {
+		return state.NewStore()
+	}
+
+	store, err := state.Open(stateFile)
+	if err != nil {
+		l.Error("Failed to load state, starting without:", "path", stateFile, "error", err)
+		return state.NewStore()
+	}
+
+	return store
+}
+
+func (s *SideCar) Run() error {
 	l.Info("Running SideCar with method:", "method", s.Method)
+	if s.Method != METHOD_WATCH && s.Method != METHOD_SLEEP && s.Method != METHOD_LIST {
+		return fmt.Errorf("invalid method %q", s.Method)
+	}
+
+	if !s.SkipRBACCheck {
+		if err := s.preflight(); err != nil {
+			return err
+		}
+	}
+
+	// a marker left by a previous run must not report this one ready
+	s.removeReadyFile()
+
+	if s.MetricsPort != "" || s.HealthPort != "" {
+		if err := s.serve(); err != nil {
+			return err
+		}
+	}
+
+	if s.LeaderElection {
+		if s.Method == METHOD_LIST {
+			l.Warn("Leader election is not used with the list method, every replica syncs once")
+			return s.run()
+		}
+
+		election := kubernetes.LeaderElection{
+			Name:          s.LeaderElectionName,
+			Namespace:     s.LeaderElectionNamespace,
+			Identity:      s.LeaderElectionIdentity,
+			LeaseDuration: s.LeaderElectionLeaseDuration,
+		}
+
+		return s.client.RunAsLeader(election, func(ctx context.Context) error {
+			// stop syncing as soon as the leadership is lost
+			s.ctx = ctx
+			for _, profile := range s.profiles {
+				profile.ctx = ctx
+			}
+			s.client.Ctx = ctx
+			s.leading.Store(true)
+
+			return s.run()
+		})
+	}
+
+	return s.run()
+}
+
+// run syncs the resources with the configured method.
+func (s *SideCar) run() error {
 	switch s.Method {
-	case METHOD_WATCH, METHOD_SLEEP:
+	case METHOD_WATCH:
 		l.Info("Waiting for changes")
-		s.syncResources()
+		if result := s.initialSync(); result.synced() {
+			s.markReady()
+		} else {
+			// the handlers retry the resources that failed, the sidecar is
+			// ready once they wrote the files of all of them
+			go s.readyOnceSynced()
+		}
 
-		s.WaitForChanges()
+		return s.WaitForChanges()
+	case METHOD_SLEEP:
+		l.Info("Polling for changes", "interval", s.SleepTime)
+		s.Poll()
 	case METHOD_LIST:
 		l.Info("Running once")
-		s.RunOnce()
-	default:
-		l.Error("Invalid method:", "error", s.Method)
+		return s.RunOnce()
+	}
+
+	return nil
+}
+
+// permissions returns the API requests the configured method needs for all
+// profiles.
+func (s *SideCar) permissions() ([]kubernetes.Permission, error) {
+	var permissions []kubernetes.Permission
+	for _, profile := range s.syncProfiles() {
+		profilePermissions, err := profile.resourcePermissions()
+		if err != nil {
+			return nil, err
+		}
+		for _, permission := range profilePermissions {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	if s.LeaderElection && s.Method != METHOD_LIST {
+		for _, verb := range []string{"get", "create", "update"} {
+			permissions = append(permissions, kubernetes.Permission{
+				Group:     "coordination.k8s.io",
+				Resource:  "leases",
+				Verb:      verb,
+				Namespace: s.LeaderElectionNamespace,
+			})
+		}
+	}
+
+	return permissions, nil
+}
+
+// resourcePermissions returns the API requests the profile needs to sync its
+// resources.
+func (s *SideCar) resourcePermissions() ([]kubernetes.Permission, error) {
+	verbs := []string{"list"}
+	namespaces := s.Namespaces
+	if s.Method == METHOD_WATCH {
+		verbs = append(verbs, "watch")
+		// with CLUSTER_WIDE_WATCH several namespaces are watched cluster wide
+		if len(namespaces) > 1 && s.ClusterWideWatch {
+			namespaces = nil
+		}
+	}
+	if s.NamespaceSelector != "" || len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var permissions []kubernetes.Permission
+	grant := func(group string, resource string, verbs ...string) {
+		for _, namespace := range namespaces {
+			for _, verb := range verbs {
+				permissions = append(permissions, kubernetes.Permission{
+					Group:     group,
+					Resource:  resource,
+					Verb:      verb,
+					Namespace: namespace,
+				})
+			}
+		}
+	}
+
+	for _, resource := range s.Resource {
+		switch resource {
+		case RESOURCE_CONFIGMAP:
+			grant("", "configmaps", verbs...)
+			if s.SyncStatusAnnotation {
+				grant("", "configmaps", "patch")
+			}
+		case RESOURCE_SECRET:
+			grant("", "secrets", verbs...)
+			if s.SyncStatusAnnotation {
+				grant("", "secrets", "patch")
+			}
+			// metadata-only watches fetch changed Secrets one by one
+			if s.Method == METHOD_WATCH && s.secretMetadataOnly() {
+				grant("", "secrets", "get")
+			}
+		case RESOURCE_CUSTOM:
+			custom, err := s.customResource()
+			if err != nil {
+				return nil, err
+			}
+			grant(custom.GVR.Group, custom.GVR.Resource, verbs...)
+			if s.SyncStatusAnnotation {
+				grant(custom.GVR.Group, custom.GVR.Resource, "patch")
+			}
+		}
+	}
+
+	if s.NamespaceSelector != "" {
+		namespaces = []string{metav1.NamespaceAll}
+		grant("", "namespaces", verbs...)
+	}
+
+	return permissions, nil
+}
+
+// preflight checks that the service account has the RBAC permissions the
+// configured method needs. Missing permissions are reported together with a
+// role granting them.
+func (s *SideCar) preflight() error {
+	permissions, err := s.permissions()
+	if err != nil {
+		return err
+	}
+
+	missing, err := s.client.MissingPermissions(permissions)
+	if err != nil {
+		return fmt.Errorf("RBAC preflight check failed: %w", err)
+	}
+
+	if len(missing) == 0 {
+		l.Info("RBAC preflight check passed", "permissions", len(permissions))
+		s.eventsPreflight()
+		return nil
+	}
+
+	for _, permission := range missing {
+		l.Error("Missing RBAC permission:", "permission", permission.String())
+	}
+	fmt.Fprintf(os.Stderr, "\nGrant the missing permissions to the service account of the sidecar, e.g. with:\n\n%s\n",
+		kubernetes.SuggestedRole("k8s-gsidecar", missing))
+
+	return fmt.Errorf("missing %d RBAC permissions, set %s=true to skip this check", len(missing), SKIP_RBAC_CHECK)
+}
+
+// eventsPreflight disables Events when the service account may not record
+// them. Events are optional, so this is no reason to fail.
+func (s *SideCar) eventsPreflight() {
+	if s.client.Events == nil {
+		return
+	}
+
+	var namespaces []string
+	for _, profile := range s.syncProfiles() {
+		profileNamespaces := profile.Namespaces
+		if profile.NamespaceSelector != "" || len(profileNamespaces) == 0 {
+			profileNamespaces = []string{metav1.NamespaceAll}
+		}
+		for _, namespace := range profileNamespaces {
+			if !slices.Contains(namespaces, namespace) {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+
+	var permissions []kubernetes.Permission
+	for _, namespace := range namespaces {
+		for _, verb := range []string{"create", "patch"} {
+			permissions = append(permissions, kubernetes.Permission{Resource: "events", Verb: verb, Namespace: namespace})
+		}
+	}
+
+	missing, err := s.client.MissingPermissions(permissions)
+	if err != nil {
+		l.Warn("Failed to check the RBAC permissions for Events:", "error", err)
+		return
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	for _, permission := range missing {
+		l.Warn("Missing RBAC permission, not recording Events:", "permission", permission.String())
+	}
+	s.client.Events = nil
+}
+
+// resourceNames parses RESOURCE_NAME, a comma separated list of resource
+// names and glob patterns. It returns nil when no name restriction is set.
+func (s *SideCar) resourceNames() *kubernetes.NameFilter {
+	if s.ResourceName == "" {
+		return nil
+	}
+
+	return kubernetes.NewNameFilter(strings.Split(s.ResourceName, ","))
+}
+
+// namespaces returns the namespaces to sync. With a namespace selector the
+// matching namespaces are looked up, otherwise the static NAMESPACE list is
+// used. none is set when the selector matches no namespace, so there is
+// nothing to sync.
+func (s *SideCar) namespaces() (namespaces []string, none bool, err error) {
+	if s.NamespaceSelector == "" {
+		return s.Namespaces, false, nil
+	}
+
+	namespaces, err = s.client.ListNamespaces(s.NamespaceSelector)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// an empty list would mean all namespaces to the client
+	if len(namespaces) == 0 {
+		l.Info("No namespace matches selector:", "selector", s.NamespaceSelector)
+		return nil, true, nil
+	}
+
+	return namespaces, false, nil
+}
+
+// secretMetadataOnly reports whether Secrets are watched with a
+// metadata-only informer.
+func (s *SideCar) secretMetadataOnly() bool {
+	if !s.SecretMetadataOnly {
+		return false
+	}
+
+	if s.client.Metadata == nil {
+		l.Warn("No metadata client available, watching full Secrets")
+		return false
+	}
+
+	return true
+}
+
+// customResource parses the CUSTOM_RESOURCE settings.
+func (s *SideCar) customResource() (*kubernetes.CustomResource, error) {
+	custom, err := kubernetes.NewCustomResource(s.CustomResource, s.CustomResourceFileName, s.CustomResourceContent)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.client.Dynamic == nil {
+		return nil, fmt.Errorf("no dynamic client available, cannot sync custom resources")
+	}
+
+	return custom, nil
+}
+
+func (s *SideCar) targetFolder(obj metav1.Object) string {
+	if s.FolderAnnotation == "" {
+		return s.Folder
+	}
+
+	return path.Join(s.Folder, obj.GetAnnotations()[s.FolderAnnotation])
+}
+
+// jsonFiles returns the JSON files of a resource.
+func (s *SideCar) jsonFiles(data map[string]string) map[string]string {
+	files := make(map[string]string, len(data))
+	for fileName, content := range data {
+		if s.writer.IsJSON(fileName) {
+			files[fileName] = content
+		}
+	}
+
+	return files
+}
+
+// syncProfiles returns the profiles to sync, just the sidecar itself when no
+// profiles are configured.
+func (s *SideCar) syncProfiles() []*SideCar {
+	if len(s.profiles) == 0 {
+		return []*SideCar{s}
+	}
+
+	return s.profiles
+}
+
+// target describes what the informer workers of the profile sync.
+func (s *SideCar) target() kubernetes.Target {
+	return kubernetes.Target{
+		Label:            s.Label,
+		LabelValue:       s.LabelValue,
+		Names:            s.resourceNames(),
+		Folder:           s.Folder,
+		FolderAnnotation: s.FolderAnnotation,
+		Writer:           s.writer,
+		Notifier:         s.notifier,
+		State:            s.state,
+		Files:            s.store(),
+	}
+}
+
+// processedStore returns the store of processed resources, nil unless
+// IGNORE_ALREADY_PROCESSED is set.
+func (s *SideCar) processedStore() *state.Store {
+	if s.state != nil {
+		return s.state
 	}
+
+	return s.client.State
 }
 
-func (s *SideCar) syncResources() {
+// store returns the store the files written for listed resources are
+// recorded in. The informer workers record their files in it as well, so
+// each file is counted once.
+func (s *SideCar) store() *state.Store {
+	if processed := s.processedStore(); processed != nil {
+		return processed
+	}
+
+	if s.files == nil {
+		s.files = state.NewStore()
+		metrics.TrackStore(s.files)
+	}
+
+	return s.files
+}
+
+// unchanged reports whether a listed resource was already synced with the
+// same resourceVersion or content. It is always false unless
+// IGNORE_ALREADY_PROCESSED is set.
+func (s *SideCar) unchanged(kind string, obj metav1.Object, folder string, files map[string]string) bool {
+	processed := s.processedStore()
+	if processed == nil {
+		return false
+	}
+
+	key := state.Key(kind, obj.GetNamespace(), obj.GetName())
+	if !processed.Unchanged(key, obj.GetResourceVersion(), state.Hash(folder, files)) {
+		return false
+	}
+
+	l.Debug(kind+" already processed:", "namespace", obj.GetNamespace(), "name", obj.GetName())
+	return true
+}
+
+// processed records the files written for a listed resource, so the workers
+// recognize it as already processed and its files can be removed once it is
+// gone. Files recorded before that the resource no longer has are removed,
+// it returns how many.
+func (s *SideCar) processed(kind string, obj metav1.Object, folder string, files map[string]string) int {
+	entry := state.Entry{
+		ResourceVersion: obj.GetResourceVersion(),
+		Hash:            state.Hash(folder, files),
+	}
+	for fileName := range files {
+		entry.Files = append(entry.Files, state.File{Folder: folder, Name: fileName})
+	}
+
+	key := state.Key(kind, obj.GetNamespace(), obj.GetName())
+	removed := 0
+	for _, file := range s.store().Set(key, entry) {
+		l.Info("File is no longer synced, removing it:", "resource", key, "fileName", file.Name)
+		if err := s.writer.Remove(file.Folder, file.Name); err != nil {
+			l.Error("Failed to remove file:", "fileName", file.Name, "error", err)
+			continue
+		}
+		removed++
+	}
+
+	return removed
+}
+
+// syncResult is the outcome of a full sync.
+type syncResult struct {
+	// written counts the files that changed
+	written int
+	// changed holds the resources whose files changed
+	changed []metav1.Object
+	// seen holds the state keys of all listed resources
+	seen map[string]struct{}
+	// failed counts the resources whose files could not be written
+	failed int
+	// inPlace holds the state keys of the resources whose files are all
+	// written, files counts these files
+	inPlace map[string]struct{}
+	files   int
+	// err is why the sync is not complete
+	err error
+	// complete is false when a resource could not be listed, seen must not be
+	// used to detect deletions then
+	complete bool
+}
+
+// synced reports whether the files of all resources were written.
+func (r *syncResult) synced() bool {
+	return r.complete && r.failed == 0
+}
+
+// syncResources writes the files of all matching resources.
+func (s *SideCar) syncResources() syncResult {
 	l.Info("Syncing resources")
+
+	result := syncResult{seen: map[string]struct{}{}, inPlace: map[string]struct{}{}}
+
+	namespaces, none, err := s.namespaces()
+	if err != nil {
+		l.Error("Failed to list namespaces:", "selector", s.NamespaceSelector, "error", err)
+		result.err = fmt.Errorf("failed to list namespaces: %w", err)
+		return result
+	}
+	if none {
+		result.complete = true
+		return result
+	}
+
+	see := func(kind string, obj metav1.Object) {
+		result.seen[state.Key(kind, obj.GetNamespace(), obj.GetName())] = struct{}{}
+	}
+	place := func(kind string, obj metav1.Object, files map[string]string) {
+		result.inPlace[state.Key(kind, obj.GetNamespace(), obj.GetName())] = struct{}{}
+		result.files += len(files)
+	}
+
 	for _, resource := range s.Resource {
 		l.Info("Syncing resource:", "resource", resource)
 		switch resource {
 		case RESOURCE_CONFIGMAP:
-			configMaps, err := s.client.GetConfigMaps(s.Namespaces, s.Label, s.LabelValue)
+			configMaps, err := s.client.GetConfigMaps(namespaces, s.Label, s.LabelValue, s.resourceNames())
 			l.Info("Got ConfigMaps:", "count", len(configMaps))
 			if err != nil {
 				l.Error("Failed to get ConfigMaps:", "error", err)
-				return
+				result.err = fmt.Errorf("failed to get ConfigMaps: %w", err)
+				return result
 			}
 
 			for _, configMap := range configMaps {
-				for fileName, data := range configMap.Data {
-					if !s.writer.IsJSON(fileName) {
-						continue
-					}
-
-					folder := s.Folder
-
-					if s.FolderAnnotation != "" {
-						folder = path.Join(s.Folder, configMap.Annotations[s.FolderAnnotation])
-					}
+				see(kubernetes.KindConfigMap, &configMap)
+				folder := s.targetFolder(&configMap)
+				files := s.jsonFiles(configMap.Data)
+				if s.unchanged(kubernetes.KindConfigMap, &configMap, folder, files) {
+					place(kubernetes.KindConfigMap, &configMap, files)
+					continue
+				}
 
-					err = s.writer.Write(folder, fileName, data)
-					if err != nil {
-						log.Fatalf("Failed to write file: %v", err)
-					}
+				written, err := s.writeFiles(kubernetes.KindConfigMap, &configMap, nil, folder, files)
+				if err != nil {
+					// not recorded, so the next sync writes it again
+					result.add(&configMap, written)
+					result.failed++
+					continue
 				}
+				result.add(&configMap, written+s.processed(kubernetes.KindConfigMap, &configMap, folder, files))
+				place(kubernetes.KindConfigMap, &configMap, files)
 			}
 
 		case RESOURCE_SECRET:
-			secrets, err := s.client.GetSecrets(s.Namespaces, s.Label, s.LabelValue)
+			secrets, err := s.client.GetSecrets(namespaces, s.Label, s.LabelValue, s.resourceNames())
 			l.Info("Got Secrets:", "count", len(secrets))
 			if err != nil {
 				l.Error("Failed to get Secrets:", "error", err)
-				return
+				result.err = fmt.Errorf("failed to get Secrets: %w", err)
+				return result
 			}
 
 			for _, secret := range secrets {
-				for fileName, data := range secret.Data {
-					if !s.writer.IsJSON(fileName) {
-						continue
-					}
-
-					folder := s.Folder
-
-					if s.FolderAnnotation != "" {
-						folder = path.Join(s.Folder, secret.Annotations[s.FolderAnnotation])
-					}
-
-					// Secret.Data is []byte, convert to string
-					err = s.writer.Write(folder, fileName, string(data))
-					if err != nil {
-						slog.Error("Failed to write file:", "error", err)
-					}
+				see(kubernetes.KindSecret, &secret)
+				// Secret.Data is []byte, convert to string
+				data := make(map[string]string, len(secret.Data))
+				for fileName, value := range secret.Data {
+					data[fileName] = string(value)
 				}
+
+				folder := s.targetFolder(&secret)
+				files := s.jsonFiles(data)
+				if s.unchanged(kubernetes.KindSecret, &secret, folder, files) {
+					place(kubernetes.KindSecret, &secret, files)
+					continue
+				}
+
+				written, err := s.writeFiles(kubernetes.KindSecret, &secret, nil, folder, files)
+				if err != nil {
+					result.add(&secret, written)
+					result.failed++
+					continue
+				}
+				result.add(&secret, written+s.processed(kubernetes.KindSecret, &secret, folder, files))
+				place(kubernetes.KindSecret, &secret, files)
+			}
+
+		case RESOURCE_CUSTOM:
+			custom, err := s.customResource()
+			if err != nil {
+				l.Error("Invalid custom resource:", "error", err)
+				result.err = err
+				return result
+			}
+
+			resources, err := s.client.GetCustomResources(custom, namespaces, s.Label, s.LabelValue, s.resourceNames())
+			l.Info("Got custom resources:", "resource", s.CustomResource, "count", len(resources))
+			if err != nil {
+				l.Error("Failed to get custom resources:", "resource", s.CustomResource, "error", err)
+				result.err = fmt.Errorf("failed to get %s: %w", s.CustomResource, err)
+				return result
+			}
+
+			for _, resource := range resources {
+				see(resource.GetKind(), &resource)
+				data, err := custom.Files(&resource)
+				if err != nil {
+					l.Error("Failed to extract file:", "name", resource.GetName(), "error", err)
+					s.event(&resource, corev1.EventTypeWarning, kubernetes.ReasonInvalidContent, "Failed to extract file: %v", err)
+					result.failed++
+					continue
+				}
+
+				folder := s.targetFolder(&resource)
+				files := s.jsonFiles(data)
+				if s.unchanged(resource.GetKind(), &resource, folder, files) {
+					place(resource.GetKind(), &resource, files)
+					continue
+				}
+
+				written, err := s.writeFiles(resource.GetKind(), &resource, custom, folder, files)
+				if err != nil {
+					result.add(&resource, written)
+					result.failed++
+					continue
+				}
+				result.add(&resource, written+s.processed(resource.GetKind(), &resource, folder, files))
+				place(resource.GetKind(), &resource, files)
+			}
+		}
+	}
+
+	result.complete = true
+	metrics.LastSync.SetToCurrentTime()
+	return result
+}
+
+// initialSync syncs the resources of every profile before the informers
+// start and combines the results. The informers find the files in place, so
+// the notifier of a profile is called here unless REQ_SKIP_INIT is set.
+func (s *SideCar) initialSync() syncResult {
+	result := syncResult{seen: map[string]struct{}{}, inPlace: map[string]struct{}{}, complete: true}
+	for _, profile := range s.syncProfiles() {
+		profileResult := profile.syncResources()
+		result.merge(profileResult)
+
+		if profileResult.written == 0 {
+			continue
+		}
+		if profile.skipInit() {
+			l.Info("Skipping initial notification", "profile", profile.name)
+			continue
+		}
+		profile.notify(profileResult.changed)
+	}
+
+	return result
+}
+
+// skipInit reports whether REQ_SKIP_INIT is set.
+func (s *SideCar) skipInit() bool {
+	skip, _ := strconv.ParseBool(s.ReqSkipInit)
+	return skip
+}
+
+// ignoreProcessed reports whether IGNORE_ALREADY_PROCESSED is set.
+func (s *SideCar) ignoreProcessed() bool {
+	ignore, _ := strconv.ParseBool(s.IgnoreAlreadyProcessed)
+	return ignore
+}
+
+// merge adds the result of another profile.
+func (r *syncResult) merge(other syncResult) {
+	r.written += other.written
+	r.changed = append(r.changed, other.changed...)
+	maps.Copy(r.seen, other.seen)
+	r.failed += other.failed
+	maps.Copy(r.inPlace, other.inPlace)
+	r.files += other.files
+	if r.err == nil {
+		r.err = other.err
+	}
+	r.complete = r.complete && other.complete
+}
+
+// add counts the files written for obj.
+func (r *syncResult) add(obj metav1.Object, written int) {
+	if written == 0 {
+		return
+	}
+
+	r.written += written
+	r.changed = append(r.changed, obj)
+}
+
+// writeFiles writes the files of a listed resource and returns how many
+// changed. Every file is attempted, the last write error is returned.
+func (s *SideCar) writeFiles(kind string, obj metav1.Object, custom *kubernetes.CustomResource, folder string, files map[string]string) (int, error) {
+	written := 0
+	var lastErr error
+
+	for fileName, data := range files {
+		if !json.Valid([]byte(data)) {
+			s.event(obj, corev1.EventTypeWarning, kubernetes.ReasonInvalidContent, "%s is not valid JSON", fileName)
+		}
+
+		changed, err := s.writer.Write(folder, fileName, data)
+		if err != nil {
+			l.Error("Failed to write file:", "name", obj.GetName(), "fileName", fileName, "error", err)
+			s.event(obj, corev1.EventTypeWarning, kubernetes.ReasonWriteFailed, "Failed to write %s: %v", path.Join(folder, fileName), err)
+			lastErr = err
+			continue
+		}
+		if changed {
+			written++
+		}
+	}
+
+	if written > 0 {
+		s.event(obj, corev1.EventTypeNormal, kubernetes.ReasonSynced, "Wrote %d files to %s", written, folder)
+	}
+
+	result := kubernetes.ReasonSynced
+	if lastErr != nil {
+		result = kubernetes.ReasonWriteFailed
+	}
+	s.client.ReportSyncStatus(kind, obj, custom, written, result)
+
+	return written, lastErr
+}
+
+// event records an Event on a listed resource.
+func (s *SideCar) event(obj metav1.Object, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if object, ok := obj.(runtime.Object); ok {
+		s.client.Event(object, eventType, reason, messageFmt, args...)
+	}
+}
+
+// notify calls the notifier and records a failure on the changed resources.
+// Without a notifier there is nothing to do.
+func (s *SideCar) notify(changed []metav1.Object) error {
+	if s.notifier == nil {
+		return nil
+	}
+
+	err := s.notifier.Notify()
+	if err == nil {
+		return nil
+	}
+
+	l.Error("Failed to notify:", "error", err)
+	for _, obj := range changed {
+		s.event(obj, corev1.EventTypeWarning, kubernetes.ReasonNotifyFailed, "Failed to notify: %v", err)
+	}
+
+	return err
+}
+
+// removeDeleted removes the files of resources synced before that were not
+// listed again and returns how many files were removed.
+func (s *SideCar) removeDeleted(seen map[string]struct{}) int {
+	removed := 0
+
+	for _, key := range s.store().Keys() {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		l.Info("Resource is gone, removing its files:", "resource", key)
+		for _, file := range s.store().Delete(key) {
+			if err := s.writer.Remove(file.Folder, file.Name); err != nil {
+				l.Error("Failed to remove file:", "fileName", file.Name, "error", err)
+				continue
 			}
+			removed++
 		}
 	}
+
+	return removed
 }
 
-func (s *SideCar) RunOnce() {
-	s.syncResources()
-	s.notifier.Notify()
+// Poll syncs all resources every SleepTime until the context is done. Files
+// of resources deleted between two polls are removed, the notifier of a
+// profile is called after every poll that changed its files.
+func (s *SideCar) Poll() {
+	for {
+		synced := true
+		for _, profile := range s.syncProfiles() {
+			result := profile.syncResources()
 
+			changed := result.written
+			if result.complete {
+				changed += profile.removeDeleted(result.seen)
+			}
+			synced = synced && result.synced()
+
+			if changed > 0 {
+				profile.notify(result.changed)
+			}
+		}
+		if synced {
+			s.markReady()
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(s.SleepTime):
+		}
+	}
 }
 
-func (s *SideCar) WaitForChanges() {
+// RunOnce syncs all resources and notifies when files changed. With
+// InitMinFiles or InitResources it syncs again every InitRetryInterval until
+// the files are in place, failing with errInitTimeout after InitTimeout. With
+// InitStrict a failed list, write or notification is an error, so an init
+// container does not let the pod start without its files.
+func (s *SideCar) RunOnce() error {
+	start := time.Now()
+
+	var timeout <-chan time.Time
+	if s.InitTimeout > 0 {
+		timer := time.NewTimer(s.InitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	profiles := s.syncProfiles()
+	var (
+		result  syncResult
+		written = make([]int, len(profiles))
+		changed = make([][]metav1.Object, len(profiles))
+	)
+	for attempt := 1; ; attempt++ {
+		result = syncResult{seen: map[string]struct{}{}, inPlace: map[string]struct{}{}, complete: true}
+		for i, profile := range profiles {
+			profileResult := profile.syncResources()
+			written[i] += profileResult.written
+			changed[i] = append(changed[i], profileResult.changed...)
+			result.merge(profileResult)
+		}
+
+		missing := s.missing(result)
+		if len(missing) == 0 {
+			break
+		}
+
+		l.Info("Waiting for files:", "missing", missing, "attempt", attempt)
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("%w after %s, missing %s", errInitTimeout, s.InitTimeout, strings.Join(missing, ", "))
+		case <-time.After(s.InitRetryInterval):
+		}
+	}
+
+	if result.synced() {
+		s.markReady()
+	}
+
+	var notifyErr error
+	total := 0
+	for i, profile := range profiles {
+		if written[i] == 0 {
+			l.Info("No files changed, skipping notification", "profile", profile.name)
+			continue
+		}
+		total += written[i]
+		if err := profile.notify(changed[i]); err != nil {
+			notifyErr = err
+		}
+	}
+
+	l.Info("Sync summary:",
+		"resources", len(result.seen),
+		"files", result.files,
+		"written", total,
+		"failed", result.failed,
+		"notified", total > 0 && notifyErr == nil,
+		"duration", time.Since(start).Round(time.Millisecond))
+
+	if !s.InitStrict {
+		return nil
+	}
+
+	switch {
+	case result.err != nil:
+		return result.err
+	case result.failed > 0:
+		return fmt.Errorf("failed to write the files of %d resources", result.failed)
+	case notifyErr != nil:
+		return fmt.Errorf("failed to notify: %w", notifyErr)
+	}
+
+	return nil
+}
+
+// missing describes what the list method still waits for after a sync, it
+// is empty when the sidecar does not wait for files.
+func (s *SideCar) missing(result syncResult) []string {
+	if s.InitMinFiles == 0 && len(s.InitResources) == 0 {
+		return nil
+	}
+
+	var missing []string
+	if result.err != nil {
+		missing = append(missing, result.err.Error())
+	}
+
+	if result.files < s.InitMinFiles {
+		missing = append(missing, fmt.Sprintf("%d of %d files", s.InitMinFiles-result.files, s.InitMinFiles))
+	}
+
+	for _, resource := range s.InitResources {
+		if !inPlace(result, strings.TrimSpace(resource)) {
+			missing = append(missing, resource)
+		}
+	}
+
+	return missing
+}
+
+// inPlace reports whether the files of the resource named namespace/name, or
+// just name in any namespace, are all written.
+func inPlace(result syncResult, resource string) bool {
+	namespace, name, found := strings.Cut(resource, "/")
+	if !found {
+		namespace, name = "", resource
+	}
+
+	for key := range result.inPlace {
+		_, keyNamespace, keyName := state.SplitKey(key)
+		if keyName == name && (namespace == "" || keyNamespace == namespace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WaitForChanges starts the informer workers of every profile and blocks
+// until the context is done. It returns the error of the first worker that
+// failed, e.g. because its cache did not sync.
+func (s *SideCar) WaitForChanges() error {
 
 	s.client.Wg = &sync.WaitGroup{}
 
 	l.Info("Start waiting for changes")
 
+	for _, profile := range s.syncProfiles() {
+		if err := profile.startWorkers(); err != nil {
+			return err
+		}
+	}
+
+	return s.wait()
+}
+
+// startWorkers starts the informer workers of the profile.
+func (s *SideCar) startWorkers() error {
+	target := s.target()
+
+	if s.NamespaceSelector != "" {
+		watched := kubernetes.WatchedResources{}
+		for _, resource := range s.Resource {
+			switch resource {
+			case RESOURCE_CONFIGMAP:
+				watched.ConfigMaps = true
+			case RESOURCE_SECRET:
+				watched.Secrets = true
+				watched.SecretMetadataOnly = s.secretMetadataOnly()
+			case RESOURCE_CUSTOM:
+				custom, err := s.customResource()
+				if err != nil {
+					return err
+				}
+				watched.Custom = custom
+			}
+		}
+
+		s.client.Wg.Add(1)
+		go s.client.NamespaceSelectorWorker(s.NamespaceSelector, watched, target)
+		return nil
+	}
+
 	for _, resource := range s.Resource {
 		switch resource {
 		case RESOURCE_CONFIGMAP:
 			s.client.Wg.Add(1)
-			go s.client.ConfigMapInformerWorker(
-				s.Namespaces,
-				s.Label,
-				s.LabelValue,
-				s.Folder,
-				s.FolderAnnotation,
-				s.writer,
-				s.notifier,
-			)
+			go s.client.ConfigMapInformerWorker(s.Namespaces, target)
 		case RESOURCE_SECRET:
+			worker := s.client.SecretInformerWorker
+			if s.secretMetadataOnly() {
+				worker = s.client.SecretMetadataInformerWorker
+			}
+
 			s.client.Wg.Add(1)
-			go s.client.SecretInformerWorker(
-				s.Namespaces,
-				s.Label,
-				s.LabelValue,
-				s.Folder,
-				s.FolderAnnotation,
-				s.writer,
-				s.notifier,
-			)
-		}
-	}
-	s.client.Wg.Wait()
+			go worker(s.Namespaces, target)
+		case RESOURCE_CUSTOM:
+			custom, err := s.customResource()
+			if err != nil {
+				return err
+			}
+
+			s.client.Wg.Add(1)
+			go s.client.CustomResourceInformerWorker(custom, s.Namespaces, target)
+		}
+	}
+
+	return nil
+}
+
+// wait blocks until all workers are done or one of them failed.
+func (s *SideCar) wait() error {
+	done := make(chan struct{})
+	go func() {
+		s.client.Wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		// the workers return once the context is done, the handlers may
+		// still be writing files or notifying
+		s.shutdown()
+		return nil
+	case err := <-s.client.Errors():
+		return err
+	}
+}
+
+// shutdown waits at most ShutdownGracePeriod for the handlers to finish and
+// removes the temporary files of the writes that were cut off.
+func (s *SideCar) shutdown() {
+	l.Info("Shutting down, waiting for handlers", "gracePeriod", s.ShutdownGracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownGracePeriod)
+	defer cancel()
+
+	if err := s.client.Shutdown(ctx); err != nil {
+		l.Warn("Shutdown grace period expired, exiting anyway:", "error", err)
+	}
+
+	if err := s.writer.Cleanup(); err != nil {
+		l.Error("Failed to remove temporary files:", "error", err)
+	}
 }
@@ -3,22 +3,41 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
 	"k8s-gsidecar/kubernetes"
+	"k8s-gsidecar/metrics"
 	"k8s-gsidecar/notifier"
+	"k8s-gsidecar/state"
 	"k8s-gsidecar/writer"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	fake "k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestSideCar_RunOnce(t *testing.T) {
@@ -69,7 +88,11 @@ func TestSideCar_RunOnce(t *testing.T) {
 		},
 	)
 
-	sideCar := New(ctx)
+	setKubeconfig(t)
+	sideCar, err := New(ctx)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
 	sideCar.client = &kubernetes.Client{
 		Ctx:    ctx,
 		Client: fakeClientset,
@@ -546,16 +569,24 @@ func TestGrafanaDashboardSidecar_NotifierFailure(t *testing.T) {
 		Label:      "grafana_dashboard",
 		LabelValue: "1",
 		Resource:   []string{RESOURCE_CONFIGMAP},
+		Folder:     testFolder,
 		ReqPayload: `{}`,
 	}
 
 	// although notifier fails, the files should still be written
-	// note: the current implementation may panic, this is just to demonstrate the test structure
-	// in practice, you may need to modify RunOnce to handle notifier errors
+	if err := sideCar.RunOnce(); err != nil {
+		t.Fatalf("Expected a failed notification to be ignored, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(testFolder, "test.json")); err != nil {
+		t.Errorf("Expected test.json to be written, got %v", err)
+	}
 
-	// this test demonstrates how to test error scenarios
-	// you may need to modify RunOnce to return errors instead of panicking
-	_ = sideCar
+	// in strict mode the failed notification is an error
+	os.RemoveAll(testFolder)
+	sideCar.InitStrict = true
+	if err := sideCar.RunOnce(); err == nil || !strings.Contains(err.Error(), "failed to notify") {
+		t.Errorf("Expected a failed notification to fail in strict mode, got %v", err)
+	}
 
 	// verify files are written successfully (even if notify fails)
 	// this part needs to be adjusted based on the actual error handling logic
@@ -575,12 +606,15 @@ func NewMockWriter() *MockWriter {
 	}
 }
 
-func (m *MockWriter) Write(folder string, fileName string, data string) error {
+func (m *MockWriter) Write(folder string, fileName string, data string) (bool, error) {
 	if m.WriteError != nil {
-		return m.WriteError
+		return false, m.WriteError
+	}
+	if current, ok := m.WrittenFiles[fileName]; ok && current == data {
+		return false, nil
 	}
 	m.WrittenFiles[fileName] = data
-	return nil
+	return true, nil
 }
 
 func (m *MockWriter) Remove(folder string, fileName string) error {
@@ -596,6 +630,10 @@ func (m *MockWriter) IsJSON(fileName string) bool {
 	return strings.HasSuffix(fileName, ".json")
 }
 
+func (m *MockWriter) Cleanup() error {
+	return nil
+}
+
 // MockNotifier 用於測試的 mock notifier
 type MockNotifier struct {
 	NotifyCount int
@@ -616,6 +654,43 @@ func (m *MockNotifier) Notify() error {
 	return nil
 }
 
+// setKubeconfig points KUBECONFIG to a kubeconfig for a cluster that is
+// never contacted, so New can build a client the tests then replace.
+func setKubeconfig(t *testing.T) {
+	t.Helper()
+
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write kubeconfig: %v", err)
+	}
+
+	t.Setenv(KUBECONFIG, kubeconfig)
+}
+
+func TestNew_InvalidKubeconfig(t *testing.T) {
+	t.Setenv(KUBECONFIG, filepath.Join(t.TempDir(), "missing"))
+
+	sideCar, err := New(context.Background())
+	if err == nil {
+		t.Fatal("Expected New to fail without a kubeconfig")
+	}
+	if sideCar != nil {
+		t.Errorf("Expected no SideCar when New fails, got %v", sideCar)
+	}
+}
+
 func TestWaitForChanges_ConfigMapAdd(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -744,6 +819,74 @@ func TestWaitForChanges_ConfigMapUpdate(t *testing.T) {
 	}
 }
 
+func TestWaitForChanges_ConfigMapRenamedKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	initialConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-dashboard",
+			Namespace: "monitoring",
+			Labels: map[string]string{
+				"grafana_dashboard": "1",
+			},
+		},
+		Data: map[string]string{
+			"old.json": `{"title": "Dashboard"}`,
+		},
+	}
+
+	fakeClientset := fake.NewSimpleClientset(initialConfigMap)
+
+	mockWriter := NewMockWriter()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:     mockWriter,
+		notifier:   NewMockNotifier(),
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(200 * time.Millisecond)
+
+	// the file of the renamed key replaces the old one
+	renamedConfigMap := initialConfigMap.DeepCopy()
+	renamedConfigMap.Data = map[string]string{
+		"new.json": `{"title": "Dashboard"}`,
+	}
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Update(ctx, renamedConfigMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["new.json"]; !ok {
+		t.Error("Expected new.json to be written")
+	}
+	if _, ok := mockWriter.WrittenFiles["old.json"]; ok {
+		t.Error("Expected old.json to be removed after the key was renamed")
+	}
+
+	if err := fakeClientset.CoreV1().ConfigMaps("monitoring").Delete(ctx, "test-dashboard", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if len(mockWriter.WrittenFiles) != 0 {
+		t.Errorf("Expected no files after the ConfigMap was deleted, got %v", mockWriter.WrittenFiles)
+	}
+}
+
 func TestWaitForChanges_ConfigMapDelete(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -1470,15 +1613,12 @@ func TestWaitForChanges_SecretAdd(t *testing.T) {
 		Wg:     &sync.WaitGroup{},
 	}
 
-	go client.SecretInformerWorker(
-		[]string{"default"},
-		"app",
-		"test",
-		"",
-		"",
-		mockWriter,
-		mockNotifier,
-	)
+	go client.SecretInformerWorker([]string{"default"}, kubernetes.Target{
+		Label:      "app",
+		LabelValue: "test",
+		Writer:     mockWriter,
+		Notifier:   mockNotifier,
+	})
 	client.Wg.Add(1)
 	time.Sleep(100 * time.Millisecond)
 
@@ -1556,15 +1696,12 @@ func TestWaitForChanges_SecretUpdate(t *testing.T) {
 	}
 
 	client.Wg.Add(1)
-	go client.SecretInformerWorker(
-		[]string{"default"},
-		"app",
-		"test",
-		"",
-		"",
-		mockWriter,
-		mockNotifier,
-	)
+	go client.SecretInformerWorker([]string{"default"}, kubernetes.Target{
+		Label:      "app",
+		LabelValue: "test",
+		Writer:     mockWriter,
+		Notifier:   mockNotifier,
+	})
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -1622,15 +1759,12 @@ func TestWaitForChanges_SecretDelete(t *testing.T) {
 	}
 
 	client.Wg.Add(1)
-	go client.SecretInformerWorker(
-		[]string{"default"},
-		"app",
-		"test",
-		"",
-		"",
-		mockWriter,
-		mockNotifier,
-	)
+	go client.SecretInformerWorker([]string{"default"}, kubernetes.Target{
+		Label:      "app",
+		LabelValue: "test",
+		Writer:     mockWriter,
+		Notifier:   mockNotifier,
+	})
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -1670,15 +1804,12 @@ func TestWaitForChanges_SecretLabelSelector(t *testing.T) {
 	// Watch for secrets with label app=grafana
 	client.Wg.Add(1)
 
-	go client.SecretInformerWorker(
-		[]string{"default"},
-		"app",
-		"grafana",
-		"",
-		"",
-		mockWriter,
-		mockNotifier,
-	)
+	go client.SecretInformerWorker([]string{"default"}, kubernetes.Target{
+		Label:      "app",
+		LabelValue: "grafana",
+		Writer:     mockWriter,
+		Notifier:   mockNotifier,
+	})
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -1764,15 +1895,12 @@ func TestWaitForChanges_SecretNonJSONFilesIgnored(t *testing.T) {
 
 	client.Wg.Add(1)
 
-	go client.SecretInformerWorker(
-		[]string{"default"},
-		"app",
-		"test",
-		"",
-		"",
-		mockWriter,
-		mockNotifier,
-	)
+	go client.SecretInformerWorker([]string{"default"}, kubernetes.Target{
+		Label:      "app",
+		LabelValue: "test",
+		Writer:     mockWriter,
+		Notifier:   mockNotifier,
+	})
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -1877,7 +2005,11 @@ func TestSideCar_SecretAndConfigMap_Both(t *testing.T) {
 		},
 	)
 
-	sideCar := New(ctx)
+	setKubeconfig(t)
+	sideCar, err := New(ctx)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
 	sideCar.client = &kubernetes.Client{
 		Ctx:    ctx,
 		Client: fakeClientset,
@@ -1957,15 +2089,12 @@ func TestWaitForChanges_SecretAllNamespaces(t *testing.T) {
 
 	// Watch all namespaces (empty slice)
 	client.Wg.Add(1)
-	go client.SecretInformerWorker(
-		[]string{},
-		"app",
-		"test",
-		"",
-		"",
-		mockWriter,
-		mockNotifier,
-	)
+	go client.SecretInformerWorker([]string{}, kubernetes.Target{
+		Label:      "app",
+		LabelValue: "test",
+		Writer:     mockWriter,
+		Notifier:   mockNotifier,
+	})
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -2006,3 +2135,2357 @@ func TestWaitForChanges_SecretAllNamespaces(t *testing.T) {
 		t.Errorf("Expected namespace3 content, got: %s", data)
 	}
 }
+
+func TestSideCar_ResourceName(t *testing.T) {
+	ctx := context.Background()
+
+	newConfigMap := func(name string, fileName string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				fileName: `{"title": "` + name + `"}`,
+			},
+		}
+	}
+
+	fakeClientset := fake.NewSimpleClientset(
+		newConfigMap("node-exporter", "node.json"),
+		newConfigMap("team-a-overview", "team-a.json"),
+		newConfigMap("team-b-overview", "team-b.json"),
+		newConfigMap("kube-state", "kube-state.json"),
+	)
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:       mockWriter,
+		notifier:     mockNotifier,
+		Namespaces:   []string{"monitoring"},
+		Label:        "grafana_dashboard",
+		LabelValue:   "1",
+		Resource:     []string{RESOURCE_CONFIGMAP},
+		ResourceName: "node-exporter, team-*",
+	}
+
+	sideCar.RunOnce()
+
+	for _, expected := range []string{"node.json", "team-a.json", "team-b.json"} {
+		if _, ok := mockWriter.WrittenFiles[expected]; !ok {
+			t.Errorf("Expected %s to be written", expected)
+		}
+	}
+
+	if _, ok := mockWriter.WrittenFiles["kube-state.json"]; ok {
+		t.Error("Expected kube-state.json NOT to be written (name not selected)")
+	}
+}
+
+func TestWaitForChanges_ResourceName(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset()
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:       mockWriter,
+		notifier:     mockNotifier,
+		Namespaces:   []string{"monitoring"},
+		Label:        "grafana_dashboard",
+		LabelValue:   "1",
+		Resource:     []string{RESOURCE_SECRET},
+		ResourceName: "selected",
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, name := range []string{"selected", "ignored"} {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string][]byte{
+				name + ".json": []byte(`{"title": "` + name + `"}`),
+			},
+		}
+
+		if _, err := fakeClientset.CoreV1().Secrets("monitoring").Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Failed to create Secret: %v", err)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["selected.json"]; !ok {
+		t.Error("Expected selected.json to be written")
+	}
+
+	if _, ok := mockWriter.WrittenFiles["ignored.json"]; ok {
+		t.Error("Expected ignored.json NOT to be written (name not selected)")
+	}
+}
+
+func TestWaitForChanges_NamespaceSelector(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newNamespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+		}
+	}
+
+	newConfigMap := func(namespace string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboards",
+				Namespace: namespace,
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				namespace + ".json": `{"title": "` + namespace + `"}`,
+			},
+		}
+	}
+
+	fakeClientset := fake.NewSimpleClientset(
+		newNamespace("team-a", map[string]string{"grafana-dashboards": "enabled"}),
+		newNamespace("team-b", nil),
+		newConfigMap("team-a"),
+		newConfigMap("team-b"),
+	)
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:            mockWriter,
+		notifier:          mockNotifier,
+		NamespaceSelector: "grafana-dashboards=enabled",
+		Label:             "grafana_dashboard",
+		LabelValue:        "1",
+		Resource:          []string{RESOURCE_CONFIGMAP},
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["team-a.json"]; !ok {
+		t.Error("Expected team-a.json to be written")
+	}
+
+	if _, ok := mockWriter.WrittenFiles["team-b.json"]; ok {
+		t.Error("Expected team-b.json NOT to be written (namespace not selected)")
+	}
+
+	// team-b joins the selection
+	teamB := newNamespace("team-b", map[string]string{"grafana-dashboards": "enabled"})
+	if _, err := fakeClientset.CoreV1().Namespaces().Update(ctx, teamB, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update Namespace: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["team-b.json"]; !ok {
+		t.Error("Expected team-b.json to be written after namespace was labelled")
+	}
+
+	// team-a leaves the selection
+	teamA := newNamespace("team-a", nil)
+	if _, err := fakeClientset.CoreV1().Namespaces().Update(ctx, teamA, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update Namespace: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["team-a.json"]; ok {
+		t.Error("Expected team-a.json to be removed after namespace was unlabelled")
+	}
+
+	found := false
+	for _, removed := range mockWriter.RemovedFiles {
+		if removed == "team-a.json" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected team-a.json to be removed, removed files: %v", mockWriter.RemovedFiles)
+	}
+}
+
+func TestWaitForChanges_InvalidNamespaceSelector(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fake.NewSimpleClientset(),
+		},
+		writer:            NewMockWriter(),
+		notifier:          NewMockNotifier(),
+		NamespaceSelector: "a=b=c",
+		Label:             "grafana_dashboard",
+		Resource:          []string{RESOURCE_CONFIGMAP},
+	}
+
+	err := sideCar.WaitForChanges()
+	if err == nil || !strings.Contains(err.Error(), "invalid namespace selector") {
+		t.Errorf("Expected the invalid namespace selector to fail, got %v", err)
+	}
+}
+
+func TestSideCar_NamespaceSelector_RunOnce(t *testing.T) {
+	ctx := context.Background()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "team-a",
+				Labels: map[string]string{"grafana-dashboards": "enabled"},
+			},
+		},
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "team-b",
+			},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboards",
+				Namespace: "team-a",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{"team-a.json": `{}`},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboards",
+				Namespace: "team-b",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{"team-b.json": `{}`},
+		},
+	)
+
+	mockWriter := NewMockWriter()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:            mockWriter,
+		notifier:          NewMockNotifier(),
+		NamespaceSelector: "grafana-dashboards=enabled",
+		Label:             "grafana_dashboard",
+		Resource:          []string{RESOURCE_CONFIGMAP},
+	}
+
+	sideCar.RunOnce()
+
+	if _, ok := mockWriter.WrittenFiles["team-a.json"]; !ok {
+		t.Error("Expected team-a.json to be written")
+	}
+
+	if _, ok := mockWriter.WrittenFiles["team-b.json"]; ok {
+		t.Error("Expected team-b.json NOT to be written (namespace not selected)")
+	}
+}
+
+func TestWaitForChanges_SecretMetadataOnly(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	secretsResource := corev1.SchemeGroupVersion.WithResource("secrets")
+
+	fakeClientset := fake.NewSimpleClientset()
+
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	fakeMetadata := metadatafake.NewSimpleMetadataClient(scheme)
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:      ctx,
+			Client:   fakeClientset,
+			Metadata: fakeMetadata,
+		},
+		writer:             mockWriter,
+		notifier:           mockNotifier,
+		Namespaces:         []string{"monitoring"},
+		Label:              "grafana_dashboard",
+		LabelValue:         "1",
+		Resource:           []string{RESOURCE_SECRET},
+		SecretMetadataOnly: true,
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(100 * time.Millisecond)
+
+	objectMeta := metav1.ObjectMeta{
+		Name:            "secret-dashboard",
+		Namespace:       "monitoring",
+		Labels:          map[string]string{"grafana_dashboard": "1"},
+		ResourceVersion: "1",
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: objectMeta,
+		Data: map[string][]byte{
+			"dashboard.json": []byte(`{"title": "Secret Dashboard"}`),
+		},
+	}
+	if _, err := fakeClientset.CoreV1().Secrets("monitoring").Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create Secret: %v", err)
+	}
+
+	// the metadata client only sees the object metadata
+	partial := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: objectMeta,
+	}
+	if err := fakeMetadata.Tracker().Create(secretsResource, partial, "monitoring"); err != nil {
+		t.Fatalf("Failed to create Secret metadata: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if data, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+		t.Error("Expected dashboard.json to be written")
+	} else if data != `{"title": "Secret Dashboard"}` {
+		t.Errorf("Expected content fetched from the API server, got: %s", data)
+	}
+
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected notifier to be called once, got %d", mockNotifier.NotifyCount)
+	}
+
+	// deletion only carries metadata, the written files must be remembered
+	if err := fakeMetadata.Tracker().Delete(secretsResource, "monitoring", "secret-dashboard"); err != nil {
+		t.Fatalf("Failed to delete Secret metadata: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if len(mockWriter.RemovedFiles) != 1 || mockWriter.RemovedFiles[0] != "dashboard.json" {
+		t.Errorf("Expected dashboard.json to be removed, got %v", mockWriter.RemovedFiles)
+	}
+}
+
+var grafanaDashboardResource = schema.GroupVersionResource{
+	Group:    "grafana.integreatly.org",
+	Version:  "v1beta1",
+	Resource: "grafanadashboards",
+}
+
+func newGrafanaDashboard(namespace string, name string, labels map[string]string, json string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"json": json,
+			},
+		},
+	}
+	obj.SetAPIVersion("grafana.integreatly.org/v1beta1")
+	obj.SetKind("GrafanaDashboard")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(labels)
+
+	return obj
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			grafanaDashboardResource: "GrafanaDashboardList",
+		},
+		objects...,
+	)
+}
+
+func TestSideCar_CustomResource_RunOnce(t *testing.T) {
+	ctx := context.Background()
+
+	fakeDynamic := newFakeDynamicClient(
+		newGrafanaDashboard("monitoring", "node-exporter", map[string]string{"grafana_dashboard": "1"}, `{"title": "Node Exporter"}`),
+		newGrafanaDashboard("monitoring", "unlabeled", nil, `{"title": "Unlabeled"}`),
+	)
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:     ctx,
+			Client:  fake.NewSimpleClientset(),
+			Dynamic: fakeDynamic,
+		},
+		writer:                 mockWriter,
+		notifier:               mockNotifier,
+		Namespaces:             []string{"monitoring"},
+		Label:                  "grafana_dashboard",
+		LabelValue:             "1",
+		Resource:               []string{RESOURCE_CUSTOM},
+		CustomResource:         "grafana.integreatly.org/v1beta1/grafanadashboards",
+		CustomResourceFileName: DEFAULT_CUSTOM_RESOURCE_FILENAME,
+		CustomResourceContent:  "{.spec.json}",
+	}
+
+	sideCar.RunOnce()
+
+	if _, ok := mockWriter.WrittenFiles["unlabeled.json"]; ok {
+		t.Error("Expected unlabeled.json not to be written")
+	}
+
+	if data, ok := mockWriter.WrittenFiles["node-exporter.json"]; !ok {
+		t.Errorf("Expected node-exporter.json to be written, got %v", mockWriter.WrittenFiles)
+	} else if data != `{"title": "Node Exporter"}` {
+		t.Errorf("Expected content of .spec.json, got: %s", data)
+	}
+
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected notifier to be called once, got %d", mockNotifier.NotifyCount)
+	}
+}
+
+func TestWaitForChanges_CustomResource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeDynamic := newFakeDynamicClient()
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:     ctx,
+			Client:  fake.NewSimpleClientset(),
+			Dynamic: fakeDynamic,
+		},
+		writer:                 mockWriter,
+		notifier:               mockNotifier,
+		Namespaces:             []string{"monitoring"},
+		Label:                  "grafana_dashboard",
+		LabelValue:             "1",
+		Resource:               []string{RESOURCE_CUSTOM},
+		CustomResource:         "grafana.integreatly.org/v1beta1/grafanadashboards",
+		CustomResourceFileName: "{.metadata.name}.json",
+		CustomResourceContent:  "{.spec.json}",
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(100 * time.Millisecond)
+
+	dashboards := fakeDynamic.Resource(grafanaDashboardResource).Namespace("monitoring")
+	dashboard := newGrafanaDashboard("monitoring", "node-exporter", map[string]string{"grafana_dashboard": "1"}, `{"title": "Node Exporter"}`)
+	if _, err := dashboards.Create(ctx, dashboard, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create GrafanaDashboard: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if data, ok := mockWriter.WrittenFiles["node-exporter.json"]; !ok {
+		t.Error("Expected node-exporter.json to be written")
+	} else if data != `{"title": "Node Exporter"}` {
+		t.Errorf("Expected content of .spec.json, got: %s", data)
+	}
+
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected notifier to be called once, got %d", mockNotifier.NotifyCount)
+	}
+
+	updated := newGrafanaDashboard("monitoring", "node-exporter", map[string]string{"grafana_dashboard": "1"}, `{"title": "Node Exporter v2"}`)
+	if _, err := dashboards.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update GrafanaDashboard: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if data := mockWriter.WrittenFiles["node-exporter.json"]; data != `{"title": "Node Exporter v2"}` {
+		t.Errorf("Expected updated content, got: %s", data)
+	}
+
+	if err := dashboards.Delete(ctx, "node-exporter", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete GrafanaDashboard: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if len(mockWriter.RemovedFiles) != 1 || mockWriter.RemovedFiles[0] != "node-exporter.json" {
+		t.Errorf("Expected node-exporter.json to be removed, got %v", mockWriter.RemovedFiles)
+	}
+}
+
+func TestSideCar_IgnoreAlreadyProcessed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "dashboard",
+				Namespace:       "monitoring",
+				Labels:          map[string]string{"grafana_dashboard": "1"},
+				ResourceVersion: "1",
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	stateFile := t.TempDir() + "/state.json"
+	newSideCar := func(w *MockWriter, n *MockNotifier) *SideCar {
+		return &SideCar{
+			ctx: ctx,
+			client: &kubernetes.Client{
+				Ctx:    ctx,
+				Client: fakeClientset,
+				State:  newStateStore(stateFile),
+			},
+			writer:     w,
+			notifier:   n,
+			Namespaces: []string{"monitoring"},
+			Label:      "grafana_dashboard",
+			LabelValue: "1",
+			Resource:   []string{RESOURCE_CONFIGMAP},
+		}
+	}
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+	newSideCar(mockWriter, mockNotifier).RunOnce()
+
+	if _, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+		t.Fatal("Expected dashboard.json to be written")
+	}
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected notifier to be called once, got %d", mockNotifier.NotifyCount)
+	}
+
+	// a restart remembers the processed resources through the state file
+	restartedWriter := NewMockWriter()
+	restartedNotifier := NewMockNotifier()
+	sideCar := newSideCar(restartedWriter, restartedNotifier)
+	sideCar.RunOnce()
+
+	if len(restartedWriter.WrittenFiles) != 0 {
+		t.Errorf("Expected no files to be written after restart, got %v", restartedWriter.WrittenFiles)
+	}
+	if restartedNotifier.NotifyCount != 0 {
+		t.Errorf("Expected no notification after restart, got %d", restartedNotifier.NotifyCount)
+	}
+
+	// the informer replays the listed resources, they must be skipped too
+	go sideCar.WaitForChanges()
+
+	time.Sleep(200 * time.Millisecond)
+
+	if len(restartedWriter.WrittenFiles) != 0 || restartedNotifier.NotifyCount != 0 {
+		t.Errorf("Expected informer to skip processed resources, got %v and %d notifications", restartedWriter.WrittenFiles, restartedNotifier.NotifyCount)
+	}
+
+	// a new resourceVersion with the same content is skipped as well
+	configMap, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Get(ctx, "dashboard", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get ConfigMap: %v", err)
+	}
+	configMap.ResourceVersion = "2"
+	configMap.Annotations = map[string]string{"touched": "true"}
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if len(restartedWriter.WrittenFiles) != 0 {
+		t.Errorf("Expected unchanged content not to be written, got %v", restartedWriter.WrittenFiles)
+	}
+
+	configMap.ResourceVersion = "3"
+	configMap.Data["dashboard.json"] = `{"title": "Dashboard v2"}`
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if data := restartedWriter.WrittenFiles["dashboard.json"]; data != `{"title": "Dashboard v2"}` {
+		t.Errorf("Expected changed content to be written, got %q", data)
+	}
+}
+
+func TestSideCar_IgnoreAlreadyProcessed_FailedWrite(t *testing.T) {
+	ctx := context.Background()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "dashboard",
+				Namespace:       "monitoring",
+				Labels:          map[string]string{"grafana_dashboard": "1"},
+				ResourceVersion: "1",
+			},
+			Data: map[string][]byte{
+				"dashboard.json": []byte(`{"title": "Dashboard"}`),
+			},
+		},
+	)
+
+	mockWriter := NewMockWriter()
+	mockWriter.WriteError = errors.New("disk full")
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+			State:  state.NewStore(),
+		},
+		writer:     mockWriter,
+		notifier:   NewMockNotifier(),
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		Resource:   []string{RESOURCE_SECRET},
+	}
+
+	result := sideCar.syncResources()
+	if result.failed != 1 || len(result.inPlace) != 0 {
+		t.Fatalf("Expected the write to fail, got failed=%d inPlace=%d", result.failed, len(result.inPlace))
+	}
+
+	// the failed resource is not remembered as processed and written again
+	mockWriter.WriteError = nil
+	result = sideCar.syncResources()
+	if result.failed != 0 || len(result.inPlace) != 1 {
+		t.Errorf("Expected the write to be retried, got failed=%d inPlace=%d", result.failed, len(result.inPlace))
+	}
+	if _, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+		t.Error("Expected dashboard.json to be written on the next sync")
+	}
+}
+
+func TestWaitForChanges_NotifyOnlyOnChange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dashboard",
+			Namespace: "monitoring",
+			Labels:    map[string]string{"grafana_dashboard": "1"},
+		},
+		Data: map[string]string{
+			"dashboard.json": `{"title": "Dashboard"}`,
+		},
+	}
+
+	fakeClientset := fake.NewSimpleClientset(configMap)
+
+	// the file already exists with the same content, e.g. after a restart
+	mockWriter := NewMockWriter()
+	mockWriter.WrittenFiles["dashboard.json"] = `{"title": "Dashboard"}`
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:     mockWriter,
+		notifier:   mockNotifier,
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+	}
+
+	sideCar.RunOnce()
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(200 * time.Millisecond)
+
+	if mockNotifier.NotifyCount != 0 {
+		t.Errorf("Expected no notification for unchanged files, got %d", mockNotifier.NotifyCount)
+	}
+
+	// only annotations change, the file content stays the same
+	touched := configMap.DeepCopy()
+	touched.Annotations = map[string]string{"touched": "true"}
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Update(ctx, touched, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if mockNotifier.NotifyCount != 0 {
+		t.Errorf("Expected no notification for unchanged files, got %d", mockNotifier.NotifyCount)
+	}
+
+	updated := touched.DeepCopy()
+	updated.Data["dashboard.json"] = `{"title": "Dashboard v2"}`
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected one notification for the changed file, got %d", mockNotifier.NotifyCount)
+	}
+}
+
+func TestSideCar_Poll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:     mockWriter,
+		notifier:   mockNotifier,
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+		SleepTime:  50 * time.Millisecond,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sideCar.Poll()
+		close(done)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+		t.Error("Expected dashboard.json to be written")
+	}
+
+	// polls without changes do not notify
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected notifier to be called once, got %d", mockNotifier.NotifyCount)
+	}
+
+	newConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "new-dashboard",
+			Namespace: "monitoring",
+			Labels:    map[string]string{"grafana_dashboard": "1"},
+		},
+		Data: map[string]string{
+			"new-dashboard.json": `{"title": "New Dashboard"}`,
+		},
+	}
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Create(ctx, newConfigMap, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["new-dashboard.json"]; !ok {
+		t.Error("Expected new-dashboard.json to be written by the next poll")
+	}
+
+	// deletions between two polls are detected
+	if err := fakeClientset.CoreV1().ConfigMaps("monitoring").Delete(ctx, "dashboard", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if len(mockWriter.RemovedFiles) != 1 || mockWriter.RemovedFiles[0] != "dashboard.json" {
+		t.Errorf("Expected dashboard.json to be removed, got %v", mockWriter.RemovedFiles)
+	}
+
+	if mockNotifier.NotifyCount != 3 {
+		t.Errorf("Expected notifier to be called 3 times, got %d", mockNotifier.NotifyCount)
+	}
+
+	// the file of a renamed key replaces the old one
+	newConfigMap.Data = map[string]string{
+		"renamed-dashboard.json": `{"title": "New Dashboard"}`,
+	}
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Update(ctx, newConfigMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["renamed-dashboard.json"]; !ok {
+		t.Error("Expected renamed-dashboard.json to be written by the next poll")
+	}
+	if _, ok := mockWriter.WrittenFiles["new-dashboard.json"]; ok {
+		t.Error("Expected new-dashboard.json to be removed after the key was renamed")
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected Poll to return when the context is done")
+	}
+}
+
+func TestWaitForChanges_ResyncRepairsDrift(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+			// client-go does not resync more often than once a second
+			ResyncPeriod: time.Second,
+		},
+		writer:     mockWriter,
+		notifier:   mockNotifier,
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(200 * time.Millisecond)
+
+	if mockNotifier.NotifyCount != 1 {
+		t.Fatalf("Expected notifier to be called once, got %d", mockNotifier.NotifyCount)
+	}
+
+	// the file is deleted locally by mistake
+	delete(mockWriter.WrittenFiles, "dashboard.json")
+
+	time.Sleep(1500 * time.Millisecond)
+
+	if data, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+		t.Error("Expected dashboard.json to be restored by the resync")
+	} else if data != `{"title": "Dashboard"}` {
+		t.Errorf("Expected original content, got: %s", data)
+	}
+
+	if mockNotifier.NotifyCount != 2 {
+		t.Errorf("Expected notifier to be called for the repair, got %d", mockNotifier.NotifyCount)
+	}
+
+	// resyncs without drift do not notify
+	time.Sleep(1500 * time.Millisecond)
+
+	if mockNotifier.NotifyCount != 2 {
+		t.Errorf("Expected no notification without drift, got %d", mockNotifier.NotifyCount)
+	}
+}
+
+func TestWaitForChanges_RepairLocalChanges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	folder := t.TempDir()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:                ctx,
+			Client:             fakeClientset,
+			RepairLocalChanges: true,
+		},
+		writer:     writer.NewFileWriter(),
+		notifier:   mockNotifier,
+		Namespaces: []string{"monitoring"},
+		Folder:     folder,
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(300 * time.Millisecond)
+
+	file := folder + "/dashboard.json"
+	expectContent := func(when string) {
+		t.Helper()
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Errorf("Expected dashboard.json %s: %v", when, err)
+		} else if string(content) != `{"title": "Dashboard"}` {
+			t.Errorf("Expected original content %s, got: %s", when, content)
+		}
+	}
+
+	expectContent("to be written")
+
+	if err := os.Remove(file); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	expectContent("to be restored after deletion")
+
+	if err := os.WriteFile(file, []byte(`{"title": "Edited"}`), 0644); err != nil {
+		t.Fatalf("Failed to edit file: %v", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	expectContent("to be restored after an edit")
+
+	// files the sidecar does not own are left alone
+	if err := os.WriteFile(folder+"/other.json", []byte(`{}`), 0644); err != nil {
+		t.Fatalf("Failed to write unmanaged file: %v", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if content, err := os.ReadFile(folder + "/other.json"); err != nil || string(content) != `{}` {
+		t.Errorf("Expected unmanaged file to be untouched, got %q, %v", content, err)
+	}
+
+	if mockNotifier.NotifyCount != 3 {
+		t.Errorf("Expected notifier to be called for the initial write and both repairs, got %d", mockNotifier.NotifyCount)
+	}
+}
+
+func TestWaitForChanges_CacheSyncTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), "", nil)
+	})
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:              ctx,
+			Client:           fakeClientset,
+			CacheSyncTimeout: 200 * time.Millisecond,
+		},
+		writer:     NewMockWriter(),
+		notifier:   NewMockNotifier(),
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sideCar.WaitForChanges()
+	}()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("Expected an error when the cache does not sync")
+		}
+		if !strings.Contains(err.Error(), "ConfigMap") || !strings.Contains(err.Error(), "monitoring") {
+			t.Errorf("Expected error to name the resource and namespace, got: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected WaitForChanges to fail fast")
+	}
+}
+
+func TestSideCar_RBACPreflight(t *testing.T) {
+	ctx := context.Background()
+
+	fakeClientset := fake.NewSimpleClientset()
+	var reviewed []string
+	fakeClientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+		attributes := review.Spec.ResourceAttributes
+		reviewed = append(reviewed, attributes.Verb+" "+attributes.Resource+" "+attributes.Namespace)
+		review.Status.Allowed = attributes.Resource != "secrets" || attributes.Verb != "watch"
+		return true, review, nil
+	})
+
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:     NewMockWriter(),
+		notifier:   mockNotifier,
+		Method:     METHOD_WATCH,
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		Resource:   []string{RESOURCE_CONFIGMAP, RESOURCE_SECRET},
+	}
+
+	err := sideCar.Run()
+	if err == nil || !strings.Contains(err.Error(), "missing 1 RBAC permissions") {
+		t.Fatalf("Expected missing secrets watch permission, got: %v", err)
+	}
+
+	expected := []string{
+		"list configmaps monitoring",
+		"watch configmaps monitoring",
+		"list secrets monitoring",
+		"watch secrets monitoring",
+	}
+	if strings.Join(reviewed, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected reviews %v, got %v", expected, reviewed)
+	}
+
+	// list mode only needs list permissions
+	reviewed = nil
+	sideCar.Method = METHOD_LIST
+	if err := sideCar.Run(); err != nil {
+		t.Errorf("Expected list mode to pass the preflight check, got: %v", err)
+	}
+	if len(reviewed) != 2 {
+		t.Errorf("Expected 2 reviews in list mode, got %v", reviewed)
+	}
+
+	// several namespaces are watched namespace scoped unless CLUSTER_WIDE_WATCH is set
+	sideCar.Method = METHOD_WATCH
+	sideCar.Namespaces = []string{"monitoring", "grafana"}
+	sideCar.Resource = []string{RESOURCE_CONFIGMAP}
+	permissions, err := sideCar.permissions()
+	if err != nil {
+		t.Fatalf("Failed to get permissions: %v", err)
+	}
+	for _, permission := range permissions {
+		if permission.Namespace == metav1.NamespaceAll {
+			t.Errorf("Expected namespaced permissions only, got %v", permission)
+		}
+	}
+
+	sideCar.ClusterWideWatch = true
+	permissions, err = sideCar.permissions()
+	if err != nil {
+		t.Fatalf("Failed to get permissions: %v", err)
+	}
+	if len(permissions) != 2 || permissions[0].Namespace != metav1.NamespaceAll {
+		t.Errorf("Expected cluster wide permissions with CLUSTER_WIDE_WATCH, got %v", permissions)
+	}
+}
+
+func TestSideCar_LeaderElection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	holder := "other-replica"
+	leaseDuration := int32(60)
+	renewTime := metav1.NewMicroTime(time.Now())
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      DEFAULT_LEADER_ELECTION_NAME,
+				Namespace: "monitoring",
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &leaseDuration,
+				AcquireTime:          &renewTime,
+				RenewTime:            &renewTime,
+			},
+		},
+	)
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:                      mockWriter,
+		notifier:                    mockNotifier,
+		Method:                      METHOD_SLEEP,
+		Namespaces:                  []string{"monitoring"},
+		Label:                       "grafana_dashboard",
+		LabelValue:                  "1",
+		Resource:                    []string{RESOURCE_CONFIGMAP},
+		SleepTime:                   50 * time.Millisecond,
+		SkipRBACCheck:               true,
+		LeaderElection:              true,
+		LeaderElectionName:          DEFAULT_LEADER_ELECTION_NAME,
+		LeaderElectionNamespace:     "monitoring",
+		LeaderElectionIdentity:      "sidecar-0",
+		LeaderElectionLeaseDuration: 1500 * time.Millisecond,
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- sideCar.Run()
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	// followers neither write nor notify
+	if len(mockWriter.WrittenFiles) != 0 || mockNotifier.NotifyCount != 0 {
+		t.Fatalf("Expected a follower not to sync, got files %v and %d notifications", mockWriter.WrittenFiles, mockNotifier.NotifyCount)
+	}
+
+	// the other replica releases the Lease
+	leases := fakeClientset.CoordinationV1().Leases("monitoring")
+	lease, err := leases.Get(ctx, DEFAULT_LEADER_ELECTION_NAME, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get lease: %v", err)
+	}
+	lease.Spec.HolderIdentity = nil
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update lease: %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	if _, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+		t.Error("Expected the leader to write dashboard.json")
+	}
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected the leader to notify once, got %d", mockNotifier.NotifyCount)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Expected Run to stop without error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Run to stop")
+	}
+
+	lease, err = fakeClientset.CoordinationV1().Leases("monitoring").Get(context.Background(), DEFAULT_LEADER_ELECTION_NAME, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+		t.Errorf("Expected the lease to be released on shutdown, held by %s", *lease.Spec.HolderIdentity)
+	}
+}
+
+// recordedEvents drains the Events recorded so far.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestSideCar_Events(t *testing.T) {
+	ctx := context.Background()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string][]byte{
+				"dashboard.json": []byte(`{"title": "Dashboard"}`),
+				"broken.json":    []byte(`{"title":`),
+			},
+		},
+	)
+
+	recorder := record.NewFakeRecorder(10)
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+	mockNotifier.NotifyError = errors.New("connection refused")
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+			Events: recorder,
+		},
+		writer:     mockWriter,
+		notifier:   mockNotifier,
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Resource:   []string{RESOURCE_SECRET},
+	}
+
+	sideCar.RunOnce()
+
+	events := strings.Join(recordedEvents(recorder), "\n")
+	for _, expected := range []string{
+		"Warning InvalidContent broken.json is not valid JSON",
+		"Normal Synced Wrote 2 files to ",
+		"Warning NotifyFailed Failed to notify: connection refused",
+	} {
+		if !strings.Contains(events, expected) {
+			t.Errorf("Expected event %q, got:\n%s", expected, events)
+		}
+	}
+
+	// write failures are recorded as well
+	mockWriter.WrittenFiles = map[string]string{}
+	mockWriter.WriteError = errors.New("read-only file system")
+	sideCar.RunOnce()
+
+	events = strings.Join(recordedEvents(recorder), "\n")
+	if !strings.Contains(events, "Warning WriteFailed Failed to write dashboard.json: read-only file system") {
+		t.Errorf("Expected a WriteFailed event, got:\n%s", events)
+	}
+	if strings.Contains(events, kubernetes.ReasonSynced) {
+		t.Errorf("Expected no Synced event without written files, got:\n%s", events)
+	}
+}
+
+func TestWaitForChanges_Events(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+	mockNotifier := NewMockNotifier()
+	mockNotifier.NotifyError = errors.New("connection refused")
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+			Events: recorder,
+		},
+		writer:     NewMockWriter(),
+		notifier:   mockNotifier,
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(100 * time.Millisecond)
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dashboard",
+			Namespace: "monitoring",
+			Labels:    map[string]string{"grafana_dashboard": "1"},
+		},
+		Data: map[string]string{
+			"dashboard.json": `{"title": "Dashboard"}`,
+		},
+	}
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	events := strings.Join(recordedEvents(recorder), "\n")
+	for _, expected := range []string{
+		"Normal Synced Wrote 1 files to ",
+		"Warning NotifyFailed Failed to notify: connection refused",
+	} {
+		if !strings.Contains(events, expected) {
+			t.Errorf("Expected event %q, got:\n%s", expected, events)
+		}
+	}
+}
+
+func TestWaitForChanges_EventsWithoutNotifier(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+
+	// without REQ_URL there is no notifier and nothing fails to notify
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+			Events: recorder,
+		},
+		writer:     NewMockWriter(),
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		LabelValue: "1",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(100 * time.Millisecond)
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dashboard",
+			Namespace: "monitoring",
+			Labels:    map[string]string{"grafana_dashboard": "1"},
+		},
+		Data: map[string]string{
+			"dashboard.json": `{"title": "Dashboard"}`,
+		},
+	}
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	events := strings.Join(recordedEvents(recorder), "\n")
+	if !strings.Contains(events, "Normal Synced Wrote 1 files to ") {
+		t.Errorf("Expected a Synced event, got:\n%s", events)
+	}
+	if strings.Contains(events, kubernetes.ReasonNotifyFailed) {
+		t.Errorf("Expected no %s event without a notifier, got:\n%s", kubernetes.ReasonNotifyFailed, events)
+	}
+}
+
+func TestWaitForChanges_SyncStatusAnnotation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewClientset()
+	var applies atomic.Int32
+	fakeClientset.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		applies.Add(1)
+		return false, nil, nil
+	})
+
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:                ctx,
+			Client:             fakeClientset,
+			SyncStatusIdentity: "sidecar-0",
+		},
+		writer:               mockWriter,
+		notifier:             mockNotifier,
+		Namespaces:           []string{"monitoring"},
+		Label:                "grafana_dashboard",
+		LabelValue:           "1",
+		Resource:             []string{RESOURCE_CONFIGMAP},
+		SyncStatusAnnotation: true,
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(100 * time.Millisecond)
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "dashboard",
+			Namespace:       "monitoring",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"grafana_dashboard": "1"},
+		},
+		Data: map[string]string{
+			"dashboard.json": `{"title": "Dashboard"}`,
+		},
+	}
+	if _, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	current, err := fakeClientset.CoreV1().ConfigMaps("monitoring").Get(ctx, "dashboard", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get ConfigMap: %v", err)
+	}
+	var status kubernetes.SyncStatus
+	if err := json.Unmarshal([]byte(current.Annotations[kubernetes.StatusAnnotation("sidecar-0")]), &status); err != nil {
+		t.Fatalf("Expected a sync status annotation, got %v: %v", current.Annotations, err)
+	}
+	if status.Result != kubernetes.ReasonSynced || status.ResourceVersion != "1" {
+		t.Errorf("Expected the Synced status of version 1, got %+v", status)
+	}
+
+	// the update caused by the annotation is neither applied nor notified again
+	if applies.Load() != 1 {
+		t.Errorf("Expected the status to be applied once, got %d", applies.Load())
+	}
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected notifier to be called once, got %d", mockNotifier.NotifyCount)
+	}
+}
+
+func TestSideCar_Metrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	port := freePort(t)
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:        metrics.NewWriter(NewMockWriter()),
+		notifier:      metrics.NewNotifier(NewMockNotifier()),
+		Method:        METHOD_LIST,
+		Namespaces:    []string{"monitoring"},
+		Label:         "grafana_dashboard",
+		LabelValue:    "1",
+		Resource:      []string{RESOURCE_CONFIGMAP},
+		SkipRBACCheck: true,
+		MetricsPort:   port,
+	}
+
+	if err := sideCar.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	resp, err := http.Get("http://127.0.0.1:" + port + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+
+	for _, expected := range []string{
+		"gsidecar_files_written_total",
+		"gsidecar_notifications_total",
+		"gsidecar_notification_duration_seconds_bucket",
+		"gsidecar_last_sync_timestamp_seconds",
+		"gsidecar_managed_files",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected metrics to contain %q", expected)
+		}
+	}
+
+	// the port is taken while the sidecar runs
+	if err := sideCar.serve(); err == nil {
+		t.Error("Expected serving on a port in use to fail")
+	}
+}
+
+func TestSideCar_MetricsManagedFiles(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	before := managedFiles(t)
+
+	// the files of the initial sync and of the informers are counted once
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:        NewMockWriter(),
+		notifier:      NewMockNotifier(),
+		Method:        METHOD_WATCH,
+		Namespaces:    []string{"monitoring"},
+		Label:         "grafana_dashboard",
+		LabelValue:    "1",
+		Resource:      []string{RESOURCE_CONFIGMAP},
+		SkipRBACCheck: true,
+	}
+
+	go sideCar.Run()
+
+	waitForManagedFiles := func(expected float64) {
+		t.Helper()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for managedFiles(t)-before != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %v managed files, got %v", expected, managedFiles(t)-before)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	waitForManagedFiles(1)
+	for !sideCar.client.FilesSynced() {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := managedFiles(t) - before; got != 1 {
+		t.Errorf("Expected 1 managed file once the informers synced, got %v", got)
+	}
+
+	if err := fakeClientset.CoreV1().ConfigMaps("monitoring").Delete(ctx, "dashboard", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete ConfigMap: %v", err)
+	}
+	waitForManagedFiles(0)
+}
+
+// managedFiles returns the value of the managed files gauge.
+func managedFiles(t *testing.T) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "gsidecar_managed_files" {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+
+	t.Fatal("Expected the managed files gauge")
+	return 0
+}
+
+// freePort returns a port nothing listens on.
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestSideCar_Health(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	port := freePort(t)
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	mockWriter := NewMockWriter()
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:        mockWriter,
+		notifier:      NewMockNotifier(),
+		Method:        METHOD_WATCH,
+		Namespaces:    []string{"monitoring"},
+		Label:         "grafana_dashboard",
+		LabelValue:    "1",
+		Resource:      []string{RESOURCE_CONFIGMAP},
+		SkipRBACCheck: true,
+		MetricsPort:   port,
+		HealthPort:    port,
+	}
+
+	go sideCar.Run()
+
+	get := func(path string) int {
+		resp, err := http.Get("http://127.0.0.1:" + port + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for get("/readyz") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the sidecar to become ready")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+		t.Error("Expected the files to be written once ready")
+	}
+	if status := get("/healthz"); status != http.StatusOK {
+		t.Errorf("Expected /healthz to return 200, got %d", status)
+	}
+	// metrics and health share the port
+	if status := get("/metrics"); status != http.StatusOK {
+		t.Errorf("Expected /metrics to return 200, got %d", status)
+	}
+}
+
+func TestSideCar_HealthWriteFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	port := freePort(t)
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	flaky := &flakyWriter{MockWriter: NewMockWriter(), failures: 100}
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:        flaky,
+		notifier:      NewMockNotifier(),
+		Method:        METHOD_WATCH,
+		Namespaces:    []string{"monitoring"},
+		Label:         "grafana_dashboard",
+		LabelValue:    "1",
+		Resource:      []string{RESOURCE_CONFIGMAP},
+		SkipRBACCheck: true,
+		HealthPort:    port,
+	}
+
+	go sideCar.Run()
+
+	get := func(path string) int {
+		resp, err := http.Get("http://127.0.0.1:" + port + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// the caches synced but the files could not be written
+	time.Sleep(1500 * time.Millisecond)
+	if !sideCar.client.CachesSynced() {
+		t.Fatal("Expected the caches to be synced")
+	}
+	if status := get("/readyz"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to return 503 while writes fail, got %d", status)
+	}
+	if status := get("/startupz"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected /startupz to return 503 while writes fail, got %d", status)
+	}
+
+	// the handler retries the write and the sidecar becomes ready
+	flaky.mu.Lock()
+	flaky.failures = 0
+	flaky.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for get("/readyz") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the sidecar to become ready")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, ok := flaky.written("dashboard.json"); !ok {
+		t.Error("Expected the files to be written once ready")
+	}
+}
+
+func TestSideCar_HealthWorkerFailed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), "", nil)
+	})
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:              ctx,
+			Client:           fakeClientset,
+			CacheSyncTimeout: 200 * time.Millisecond,
+		},
+		writer:     NewMockWriter(),
+		notifier:   NewMockNotifier(),
+		Method:     METHOD_WATCH,
+		Namespaces: []string{"monitoring"},
+		Label:      "grafana_dashboard",
+		Resource:   []string{RESOURCE_CONFIGMAP},
+	}
+
+	if err := sideCar.WaitForChanges(); err == nil {
+		t.Fatal("Expected an error when the cache does not sync")
+	}
+
+	recorder := httptest.NewRecorder()
+	sideCar.healthz(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /healthz to return 503 after a worker failed, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	sideCar.readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to return 503 before the initial sync, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	sideCar.startupz(recorder, httptest.NewRequest(http.MethodGet, "/startupz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /startupz to return 503 before the initial sync, got %d", recorder.Code)
+	}
+
+	// a replica waiting for the Lease has started, the leader syncs
+	sideCar.LeaderElection = true
+	recorder = httptest.NewRecorder()
+	sideCar.startupz(recorder, httptest.NewRequest(http.MethodGet, "/startupz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected /startupz to return 200 while following, got %d", recorder.Code)
+	}
+
+	// once started, only a failed informer makes the sidecar unready
+	sideCar.markReady()
+	recorder = httptest.NewRecorder()
+	sideCar.startupz(recorder, httptest.NewRequest(http.MethodGet, "/startupz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected /startupz to return 200 after the initial sync, got %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	sideCar.readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to return 503 after a worker failed, got %d", recorder.Code)
+	}
+}
+
+func TestSideCar_ReadyFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	readyFile := filepath.Join(t.TempDir(), "ready")
+	if err := os.WriteFile(readyFile, []byte("stale\n"), 0644); err != nil {
+		t.Fatalf("Failed to write readiness file: %v", err)
+	}
+
+	newSideCar := func(fakeClientset *fake.Clientset) *SideCar {
+		return &SideCar{
+			ctx: ctx,
+			client: &kubernetes.Client{
+				Ctx:    ctx,
+				Client: fakeClientset,
+			},
+			writer:        NewMockWriter(),
+			notifier:      NewMockNotifier(),
+			Method:        METHOD_LIST,
+			Namespaces:    []string{"monitoring"},
+			Label:         "grafana_dashboard",
+			Resource:      []string{RESOURCE_CONFIGMAP},
+			SkipRBACCheck: true,
+			ReadyFile:     readyFile,
+		}
+	}
+
+	// a failed sync removes the marker of the previous run
+	failing := fake.NewSimpleClientset()
+	failing.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), "", nil)
+	})
+	if err := newSideCar(failing).Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, err := os.Stat(readyFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the readiness file to be removed, got %v", err)
+	}
+
+	if err := newSideCar(fake.NewSimpleClientset()).Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, err := os.Stat(readyFile); err != nil {
+		t.Errorf("Expected the readiness file to be written, got %v", err)
+	}
+}
+
+func TestSideCar_WatchInitialNotification(t *testing.T) {
+	for _, skipInit := range []string{"", "true"} {
+		t.Run("skipInit="+skipInit, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			fakeClientset := fake.NewSimpleClientset(
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "dashboard",
+						Namespace: "monitoring",
+						Labels:    map[string]string{"grafana_dashboard": "1"},
+					},
+					Data: map[string]string{"dashboard.json": `{"title": "Dashboard"}`},
+				},
+			)
+
+			mockWriter := NewMockWriter()
+			mockNotifier := NewMockNotifier()
+			sideCar := &SideCar{
+				ctx: ctx,
+				client: &kubernetes.Client{
+					Ctx:    ctx,
+					Client: fakeClientset,
+				},
+				writer:        mockWriter,
+				notifier:      mockNotifier,
+				Method:        METHOD_WATCH,
+				Namespaces:    []string{"monitoring"},
+				Label:         "grafana_dashboard",
+				Resource:      []string{RESOURCE_CONFIGMAP},
+				ReqSkipInit:   skipInit,
+				SkipRBACCheck: true,
+			}
+
+			go sideCar.Run()
+
+			time.Sleep(200 * time.Millisecond)
+
+			if _, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+				t.Fatal("Expected dashboard.json to be written")
+			}
+
+			expected := 1
+			if skipInit == "true" {
+				expected = 0
+			}
+			if mockNotifier.NotifyCount != expected {
+				t.Errorf("Expected %d notifications after the initial sync, got %d", expected, mockNotifier.NotifyCount)
+			}
+		})
+	}
+}
+
+func TestSideCar_InitStrict(t *testing.T) {
+	ctx := context.Background()
+
+	fakeClientset := fake.NewSimpleClientset()
+	fakeClientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), "", nil)
+	})
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:        NewMockWriter(),
+		notifier:      NewMockNotifier(),
+		Method:        METHOD_LIST,
+		Namespaces:    []string{"monitoring"},
+		Label:         "grafana_dashboard",
+		Resource:      []string{RESOURCE_CONFIGMAP},
+		SkipRBACCheck: true,
+	}
+
+	if err := sideCar.Run(); err != nil {
+		t.Errorf("Expected a failed list to be ignored, got %v", err)
+	}
+
+	sideCar.InitStrict = true
+	err := sideCar.Run()
+	if err == nil || !strings.Contains(err.Error(), "failed to get ConfigMaps") {
+		t.Fatalf("Expected a failed list to fail in strict mode, got %v", err)
+	}
+	if code := exitCode(err); code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+}
+
+func TestSideCar_InitStrict_WriteFailed(t *testing.T) {
+	ctx := context.Background()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{"dashboard.json": `{"title": "Dashboard"}`},
+		},
+	)
+
+	mockWriter := NewMockWriter()
+	mockWriter.WriteError = errors.New("read-only file system")
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:        mockWriter,
+		notifier:      NewMockNotifier(),
+		Method:        METHOD_LIST,
+		Namespaces:    []string{"monitoring"},
+		Label:         "grafana_dashboard",
+		Resource:      []string{RESOURCE_CONFIGMAP},
+		SkipRBACCheck: true,
+	}
+
+	// a ConfigMap that cannot be written is counted as failed like a Secret
+	if result := sideCar.syncResources(); result.failed != 1 || len(result.inPlace) != 0 {
+		t.Errorf("Expected the ConfigMap to fail, got failed=%d inPlace=%d", result.failed, len(result.inPlace))
+	}
+
+	sideCar.InitStrict = true
+	err := sideCar.Run()
+	if err == nil || !strings.Contains(err.Error(), "failed to write the files of 1 resources") {
+		t.Fatalf("Expected a failed write to fail in strict mode, got %v", err)
+	}
+	if code := exitCode(err); code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+}
+
+func TestSideCar_InitStrict_NoNotifier(t *testing.T) {
+	ctx := context.Background()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{"dashboard.json": `{"title": "Dashboard"}`},
+		},
+	)
+
+	mockWriter := NewMockWriter()
+
+	// without REQ_URL there is no notifier, the files are written all the same
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:        mockWriter,
+		Method:        METHOD_LIST,
+		Namespaces:    []string{"monitoring"},
+		Label:         "grafana_dashboard",
+		Resource:      []string{RESOURCE_CONFIGMAP},
+		InitStrict:    true,
+		SkipRBACCheck: true,
+	}
+
+	if err := sideCar.Run(); err != nil {
+		t.Fatalf("Expected a strict run without a notifier to succeed, got %v", err)
+	}
+	if _, ok := mockWriter.WrittenFiles["dashboard.json"]; !ok {
+		t.Error("Expected dashboard.json to be written")
+	}
+}
+
+func TestSideCar_InitWaitForResources(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	configMap := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				name + ".json": `{"title": "Dashboard"}`,
+			},
+		}
+	}
+
+	fakeClientset := fake.NewSimpleClientset(configMap("first"))
+	mockWriter := NewMockWriter()
+	mockNotifier := NewMockNotifier()
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:            mockWriter,
+		notifier:          mockNotifier,
+		Namespaces:        []string{"monitoring"},
+		Label:             "grafana_dashboard",
+		LabelValue:        "1",
+		Resource:          []string{RESOURCE_CONFIGMAP},
+		InitStrict:        true,
+		InitMinFiles:      2,
+		InitResources:     []string{"monitoring/second"},
+		InitTimeout:       3 * time.Second,
+		InitRetryInterval: 50 * time.Millisecond,
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		fakeClientset.CoreV1().ConfigMaps("monitoring").Create(ctx, configMap("second"), metav1.CreateOptions{})
+	}()
+
+	if err := sideCar.RunOnce(); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+
+	for _, fileName := range []string{"first.json", "second.json"} {
+		if _, ok := mockWriter.WrittenFiles[fileName]; !ok {
+			t.Errorf("Expected %s to be written", fileName)
+		}
+	}
+	if mockNotifier.NotifyCount != 1 {
+		t.Errorf("Expected one notification for all attempts, got %d", mockNotifier.NotifyCount)
+	}
+}
+
+func TestSideCar_InitTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboard",
+				Namespace: "monitoring",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{
+				"dashboard.json": `{"title": "Dashboard"}`,
+			},
+		},
+	)
+
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:            NewMockWriter(),
+		notifier:          NewMockNotifier(),
+		Namespaces:        []string{"monitoring"},
+		Label:             "grafana_dashboard",
+		LabelValue:        "1",
+		Resource:          []string{RESOURCE_CONFIGMAP},
+		InitResources:     []string{"dashboard", "other/dashboard", "missing"},
+		InitTimeout:       200 * time.Millisecond,
+		InitRetryInterval: 50 * time.Millisecond,
+	}
+
+	err := sideCar.RunOnce()
+	if !errors.Is(err, errInitTimeout) {
+		t.Fatalf("Expected RunOnce to time out, got %v", err)
+	}
+	if !strings.HasSuffix(err.Error(), "missing other/dashboard, missing") {
+		t.Errorf("Expected the error to name the missing resources only, got %v", err)
+	}
+	if code := exitCode(err); code != exitTimeout {
+		t.Errorf("Expected exit code %d, got %d", exitTimeout, code)
+	}
+}
+
+// flakyWriter fails the first writes.
+type flakyWriter struct {
+	*MockWriter
+	mu       sync.Mutex
+	failures int
+}
+
+func (w *flakyWriter) Write(folder string, fileName string, data string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failures > 0 {
+		w.failures--
+		return false, errors.New("disk full")
+	}
+
+	return w.MockWriter.Write(folder, fileName, data)
+}
+
+func (w *flakyWriter) written(fileName string) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, ok := w.WrittenFiles[fileName]
+	return data, ok
+}
+
+func TestWaitForChanges_RetriesFailedWrites(t *testing.T) {
+	// with IGNORE_ALREADY_PROCESSED the failed resource must not be recorded
+	// as processed, the retry would be skipped
+	for _, tt := range []struct {
+		name  string
+		store *state.Store
+	}{
+		{"without state", nil},
+		{"with state", state.NewStore()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			fakeClientset := fake.NewSimpleClientset(
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            "dashboard",
+						Namespace:       "monitoring",
+						Labels:          map[string]string{"grafana_dashboard": "1"},
+						ResourceVersion: "1",
+					},
+					Data: map[string]string{
+						"dashboard.json": `{"title": "Dashboard"}`,
+					},
+				},
+			)
+
+			mockWriter := &flakyWriter{MockWriter: NewMockWriter(), failures: 2}
+			mockNotifier := NewMockNotifier()
+			sideCar := &SideCar{
+				ctx: ctx,
+				client: &kubernetes.Client{
+					Ctx:    ctx,
+					Client: fakeClientset,
+					State:  tt.store,
+				},
+				writer:              mockWriter,
+				notifier:            mockNotifier,
+				Namespaces:          []string{"monitoring"},
+				Label:               "grafana_dashboard",
+				LabelValue:          "1",
+				Resource:            []string{RESOURCE_CONFIGMAP},
+				ShutdownGracePeriod: 5 * time.Second,
+			}
+
+			errs := make(chan error, 1)
+			go func() {
+				errs <- sideCar.WaitForChanges()
+			}()
+
+			// the retries back off 500ms and 1s
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if _, ok := mockWriter.written("dashboard.json"); ok {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("Expected the failed write to be retried")
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			// the handlers are done once WaitForChanges returned
+			cancel()
+			if err := <-errs; err != nil {
+				t.Fatalf("WaitForChanges failed: %v", err)
+			}
+
+			if mockNotifier.NotifyCount != 1 {
+				t.Errorf("Expected one notification once the retry wrote the file, got %d", mockNotifier.NotifyCount)
+			}
+		})
+	}
+}
+
+func TestWaitForChanges_NamespaceSelectorRetriesFailedWrites(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fakeClientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dashboards",
+				Namespace: "team-a",
+				Labels:    map[string]string{"grafana_dashboard": "1"},
+			},
+			Data: map[string]string{"team-a.json": `{"title": "team-a"}`},
+		},
+	)
+
+	mockWriter := &flakyWriter{MockWriter: NewMockWriter(), failures: 1}
+	sideCar := &SideCar{
+		ctx: ctx,
+		client: &kubernetes.Client{
+			Ctx:    ctx,
+			Client: fakeClientset,
+		},
+		writer:            mockWriter,
+		notifier:          NewMockNotifier(),
+		NamespaceSelector: "grafana-dashboards=enabled",
+		Label:             "grafana_dashboard",
+		Resource:          []string{RESOURCE_CONFIGMAP},
+	}
+
+	go sideCar.WaitForChanges()
+
+	time.Sleep(200 * time.Millisecond)
+
+	// the resources of a namespace entering the selection are queued, a
+	// failed write is retried
+	teamA := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"grafana-dashboards": "enabled"}}}
+	if _, err := fakeClientset.CoreV1().Namespaces().Update(ctx, teamA, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update Namespace: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := mockWriter.written("team-a.json"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the failed write to be retried")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
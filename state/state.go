@@ -0,0 +1,261 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"k8s-gsidecar/logger"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var l *slog.Logger = logger.GetLogger()
+
+// File is a file written for a resource.
+type File struct {
+	Folder string `json:"folder"`
+	Name   string `json:"name"`
+}
+
+// Entry is what the store remembers about a processed resource.
+type Entry struct {
+	ResourceVersion string `json:"resourceVersion"`
+	Hash            string `json:"hash"`
+	Files           []File `json:"files"`
+}
+
+// Store remembers which files were written for each resource, so they can
+// be removed when the resource content is no longer available, and the
+// resourceVersion and content hash it was written with, so unchanged
+// resources can be skipped. With a path every change is persisted to a state
+// file, keeping the knowledge across restarts. It is safe for concurrent use.
+type Store struct {
+	mu        sync.Mutex
+	path      string
+	resources map[string]Entry
+}
+
+func NewStore() *Store {
+	return &Store{
+		resources: map[string]Entry{},
+	}
+}
+
+// Open returns a store persisted to the state file at path, loading the
+// entries of a previous run if the file exists.
+func Open(path string) (*Store, error) {
+	s := NewStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.resources); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if s.resources == nil {
+		s.resources = map[string]Entry{}
+	}
+
+	return s, nil
+}
+
+// Key identifies a resource in the store.
+func Key(kind string, namespace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+// SplitKey returns the kind, namespace and name of a key created by Key.
+func SplitKey(key string) (kind string, namespace string, name string) {
+	parts := strings.SplitN(key, "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+
+	return parts[0], parts[1], parts[2]
+}
+
+// Hash returns a hash of the files a resource writes into folder.
+func Hash(folder string, data map[string]string) string {
+	fileNames := make([]string, 0, len(data))
+	for fileName := range data {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s", len(folder), folder)
+	for _, fileName := range fileNames {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(fileName), fileName, len(data[fileName]), data[fileName])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Set records the resource as processed and returns the files recorded for
+// it before that it no longer has, they are left to the caller to remove.
+func (s *Store) Set(key string, entry Entry) []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := make(map[File]struct{}, len(entry.Files))
+	for _, file := range entry.Files {
+		files[file] = struct{}{}
+	}
+	var dropped []File
+	for _, file := range s.resources[key].Files {
+		if _, ok := files[file]; !ok {
+			dropped = append(dropped, file)
+		}
+	}
+
+	entry.Files = append([]File(nil), entry.Files...)
+	s.resources[key] = entry
+	s.save()
+
+	return dropped
+}
+
+// Processed reports whether the resource was processed with the given
+// resourceVersion.
+func (s *Store) Processed(key string, resourceVersion string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.resources[key]
+	return ok && resourceVersion != "" && entry.ResourceVersion == resourceVersion
+}
+
+// Unchanged reports whether the resource was processed with the given
+// resourceVersion or content hash. A new resourceVersion with the same
+// content is recorded, so it is recognized without hashing next time.
+func (s *Store) Unchanged(key string, resourceVersion string, hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.resources[key]
+	if !ok {
+		return false
+	}
+
+	if resourceVersion != "" && entry.ResourceVersion == resourceVersion {
+		return true
+	}
+
+	if entry.Hash != hash {
+		return false
+	}
+
+	entry.ResourceVersion = resourceVersion
+	s.resources[key] = entry
+	s.save()
+
+	return true
+}
+
+// Owner returns the key of the resource the file was written for.
+func (s *Store) Owner(folder string, name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	folder = filepath.Clean(folder)
+	for key, entry := range s.resources {
+		for _, file := range entry.Files {
+			if file.Name == name && filepath.Clean(file.Folder) == folder {
+				return key, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Keys returns the keys of all recorded resources.
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.resources))
+	for key := range s.resources {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// Delete forgets the resource and returns the files recorded for it.
+func (s *Store) Delete(key string) []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.resources[key]
+	if !ok {
+		return nil
+	}
+
+	delete(s.resources, key)
+	s.save()
+
+	return entry.Files
+}
+
+// Files returns how many files are recorded for all resources.
+func (s *Store) Files() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := 0
+	for _, entry := range s.resources {
+		files += len(entry.Files)
+	}
+
+	return files
+}
+
+// save writes the store to its state file, if any. A failure is logged and
+// only costs a rewrite of the files after a restart. Callers hold s.mu.
+func (s *Store) save() {
+	if s.path == "" {
+		return
+	}
+
+	data, err := json.Marshal(s.resources)
+	if err != nil {
+		l.Error("Failed to encode state:", "error", err)
+		return
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated
+	// state file behind
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		l.Error("Failed to write state file:", "path", s.path, "error", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		l.Error("Failed to write state file:", "path", s.path, "error", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		l.Error("Failed to write state file:", "path", s.path, "error", err)
+		return
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		l.Error("Failed to write state file:", "path", s.path, "error", err)
+	}
+}
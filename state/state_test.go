@@ -0,0 +1,104 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_Unchanged(t *testing.T) {
+	s := NewStore()
+	key := Key("ConfigMap", "default", "dashboard")
+	hash := Hash("/tmp/dashboards", map[string]string{"dashboard.json": `{"title": "A"}`})
+
+	if s.Unchanged(key, "1", hash) {
+		t.Error("Expected unknown resource to be changed")
+	}
+
+	s.Set(key, Entry{ResourceVersion: "1", Hash: hash})
+
+	if !s.Processed(key, "1") || !s.Unchanged(key, "1", "") {
+		t.Error("Expected same resourceVersion to be unchanged")
+	}
+
+	// a resync with a new resourceVersion but the same content
+	if !s.Unchanged(key, "2", hash) {
+		t.Error("Expected same content to be unchanged")
+	}
+	if !s.Processed(key, "2") {
+		t.Error("Expected new resourceVersion to be recorded")
+	}
+
+	other := Hash("/tmp/dashboards", map[string]string{"dashboard.json": `{"title": "B"}`})
+	if s.Unchanged(key, "3", other) {
+		t.Error("Expected new content to be changed")
+	}
+
+	moved := Hash("/tmp/other", map[string]string{"dashboard.json": `{"title": "A"}`})
+	if moved == hash {
+		t.Error("Expected folder to be part of the hash")
+	}
+}
+
+func TestStore_StateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open missing state file: %v", err)
+	}
+
+	key := Key("Secret", "monitoring", "dashboard")
+	files := []File{{Folder: "/tmp/dashboards", Name: "dashboard.json"}}
+	s.Set(key, Entry{ResourceVersion: "7", Hash: "abc", Files: files})
+	s.Set(Key("Secret", "monitoring", "deleted"), Entry{ResourceVersion: "8"})
+	s.Delete(Key("Secret", "monitoring", "deleted"))
+
+	restarted, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen state file: %v", err)
+	}
+
+	if !restarted.Processed(key, "7") {
+		t.Error("Expected resource to be remembered across restarts")
+	}
+	if restarted.Processed(Key("Secret", "monitoring", "deleted"), "8") {
+		t.Error("Expected deleted resource to be forgotten")
+	}
+	if got := restarted.Delete(key); len(got) != 1 || got[0] != files[0] {
+		t.Errorf("Expected recorded files %v, got %v", files, got)
+	}
+}
+
+func TestStore_SetDropped(t *testing.T) {
+	s := NewStore()
+	key := Key("ConfigMap", "monitoring", "dashboard")
+	kept := File{Folder: "/tmp/dashboards", Name: "kept.json"}
+	renamed := File{Folder: "/tmp/dashboards", Name: "old.json"}
+
+	if dropped := s.Set(key, Entry{Files: []File{kept, renamed}}); len(dropped) != 0 {
+		t.Errorf("Expected no dropped files for a new resource, got %v", dropped)
+	}
+
+	dropped := s.Set(key, Entry{Files: []File{kept, {Folder: "/tmp/dashboards", Name: "new.json"}}})
+	if len(dropped) != 1 || dropped[0] != renamed {
+		t.Errorf("Expected %v to be dropped, got %v", renamed, dropped)
+	}
+}
+
+func TestStore_Owner(t *testing.T) {
+	s := NewStore()
+	key := Key("ConfigMap", "monitoring", "dashboard")
+	s.Set(key, Entry{Files: []File{{Folder: "/tmp/dashboards/", Name: "dashboard.json"}}})
+
+	if owner, ok := s.Owner("/tmp/dashboards", "dashboard.json"); !ok || owner != key {
+		t.Errorf("Expected %s to own the file, got %q", key, owner)
+	}
+	if _, ok := s.Owner("/tmp/dashboards", "other.json"); ok {
+		t.Error("Expected unmanaged file to have no owner")
+	}
+
+	kind, namespace, name := SplitKey(key)
+	if kind != "ConfigMap" || namespace != "monitoring" || name != "dashboard" {
+		t.Errorf("Expected key to be split, got %q %q %q", kind, namespace, name)
+	}
+}
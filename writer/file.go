@@ -1,16 +1,27 @@
 package writer
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path"
 	"strings"
+	"sync"
 )
 
+// tempPrefix starts the names of the temporary files a write goes through.
+const tempPrefix = ".gsidecar-"
+
 type FileWriter struct {
+	mu sync.Mutex
+	// temps holds the temporary files of writes in flight
+	temps map[string]struct{}
 }
 
 func NewFileWriter() *FileWriter {
-	return &FileWriter{}
+	return &FileWriter{
+		temps: map[string]struct{}{},
+	}
 }
 
 func (f *FileWriter) Init(folder string) {
@@ -19,11 +30,41 @@ func (f *FileWriter) Init(folder string) {
 	}
 }
 
-func (f *FileWriter) Write(folder string, fileName string, data string) error {
+// Write writes data to a temporary file next to the file and renames it, so
+// readers never see a file that is only partly written.
+func (f *FileWriter) Write(folder string, fileName string, data string) (bool, error) {
 	f.Init(folder)
 	filePath := path.Join(folder, fileName)
 
-	return os.WriteFile(filePath, []byte(data), 0644)
+	// rewriting unchanged content would still bump the mtime and wake up file
+	// watchers of the consumer
+	if current, err := os.ReadFile(filePath); err == nil && string(current) == data {
+		return false, nil
+	}
+
+	temp, err := os.CreateTemp(folder, tempPrefix+fileName+"-*")
+	if err != nil {
+		return false, err
+	}
+	f.track(temp.Name(), true)
+	defer f.track(temp.Name(), false)
+
+	_, err = temp.WriteString(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return false, err
+	}
+
+	return true, nil
 }
 
 func (f *FileWriter) Remove(folder string, fileName string) error {
@@ -35,3 +76,31 @@ func (f *FileWriter) Remove(folder string, fileName string) error {
 func (f *FileWriter) IsJSON(fileName string) bool {
 	return strings.HasSuffix(fileName, ".json")
 }
+
+// Cleanup removes the temporary files of writes that are still in flight,
+// e.g. when shutting down did not wait for them.
+func (f *FileWriter) Cleanup() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var errs []error
+	for name := range f.temps {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		delete(f.temps, name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (f *FileWriter) track(name string, inFlight bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if inFlight {
+		f.temps[name] = struct{}{}
+	} else {
+		delete(f.temps, name)
+	}
+}
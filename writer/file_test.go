@@ -3,6 +3,7 @@ package writer
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestFileWriter_NestedDirectory(t *testing.T) {
@@ -10,7 +11,7 @@ func TestFileWriter_NestedDirectory(t *testing.T) {
 	defer os.RemoveAll("test-nested")
 
 	fw := NewFileWriter()
-	err := fw.Write(testFolder, "test.txt", "content")
+	_, err := fw.Write(testFolder, "test.txt", "content")
 	if err != nil {
 		t.Fatalf("Failed to write to nested directory: %v", err)
 	}
@@ -19,3 +20,81 @@ func TestFileWriter_NestedDirectory(t *testing.T) {
 		t.Errorf("File was not created")
 	}
 }
+
+func TestFileWriter_SkipsUnchangedContent(t *testing.T) {
+	testFolder := t.TempDir()
+
+	fw := NewFileWriter()
+	if written, err := fw.Write(testFolder, "test.json", "content"); err != nil || !written {
+		t.Fatalf("Expected new file to be written, got %v, %v", written, err)
+	}
+
+	// backdate the file so a rewrite would be visible in the mtime
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(testFolder+"/test.json", past, past); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+
+	if written, err := fw.Write(testFolder, "test.json", "content"); err != nil || written {
+		t.Errorf("Expected unchanged file not to be written, got %v, %v", written, err)
+	}
+
+	info, err := os.Stat(testFolder + "/test.json")
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("Expected mtime to be kept, got %v", info.ModTime())
+	}
+
+	if written, err := fw.Write(testFolder, "test.json", "changed"); err != nil || !written {
+		t.Errorf("Expected changed file to be written, got %v, %v", written, err)
+	}
+}
+
+func TestFileWriter_LeavesNoTempFiles(t *testing.T) {
+	testFolder := t.TempDir()
+	fw := NewFileWriter()
+
+	for _, data := range []string{"content", "changed"} {
+		if _, err := fw.Write(testFolder, "test.json", data); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(testFolder)
+	if err != nil {
+		t.Fatalf("Failed to read folder: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "test.json" {
+		t.Errorf("Expected only test.json in the folder, got %v", entries)
+	}
+
+	info, err := os.Stat(testFolder + "/test.json")
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Expected mode 0644, got %v", info.Mode().Perm())
+	}
+}
+
+func TestFileWriter_Cleanup(t *testing.T) {
+	testFolder := t.TempDir()
+	fw := NewFileWriter()
+
+	// a write cut off after creating its temporary file
+	temp, err := os.CreateTemp(testFolder, tempPrefix+"test.json-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	temp.Close()
+	fw.track(temp.Name(), true)
+
+	if err := fw.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(temp.Name()); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be removed, got %v", err)
+	}
+}
@@ -1,7 +1,11 @@
 package writer
 
 type IWriter interface {
-	Write(folder string, fileName string, data string) error
+	// Write writes data to the file unless it already has that content and
+	// reports whether the file was written.
+	Write(folder string, fileName string, data string) (bool, error)
 	Remove(folder string, fileName string) error
 	IsJSON(fileName string) bool
+	// Cleanup removes temporary files left by writes that did not finish.
+	Cleanup() error
 }